@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Archive of downloaded videos, one "youtube <video_id>" line per video
+// The file is locked while used so several processes can share it
+type Archive struct {
+	filename string
+}
+
+// Create an archive backed by the given file, created on first write
+func NewArchive(filename string) *Archive {
+	return &Archive{filename: filename}
+}
+
+// Entry of a video in the archive
+func archiveEntry(videoId string) string {
+	return "youtube " + videoId
+}
+
+// Look for an entry in an opened archive file
+func scanArchive(f *os.File, entry string) (bool, error) {
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == entry {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// Check if a video is recorded in the archive
+func (a *Archive) Contains(videoId string) (bool, error) {
+
+	f, err := os.Open(a.filename)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	if err := lockFile(f, false); err != nil {
+		return false, err
+	}
+	defer unlockFile(f)
+
+	return scanArchive(f, archiveEntry(videoId))
+}
+
+// Record a video in the archive, unless another process already did
+func (a *Archive) Add(videoId string) error {
+
+	f, err := os.OpenFile(a.filename, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := lockFile(f, true); err != nil {
+		return err
+	}
+	defer unlockFile(f)
+
+	entry := archiveEntry(videoId)
+	found, err := scanArchive(f, entry)
+	if err != nil || found {
+		return err
+	}
+
+	_, err = fmt.Fprintln(f, entry)
+	return err
+}
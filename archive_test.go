@@ -0,0 +1,51 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestArchive(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gotubedl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	archive := NewArchive(filepath.Join(dir, "archive.txt"))
+
+	// Missing file is an empty archive
+	if found, err := archive.Contains(videoId); err != nil || found {
+		t.Fatalf("expected empty archive, got %v %v", found, err)
+	}
+
+	// Concurrent writers, with duplicates
+	ids := []string{videoId, "9bZkp7q19f0", "kJQP7kiw5Fk", videoId}
+	var wg sync.WaitGroup
+	for _, id := range ids {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			if err := NewArchive(archive.filename).Add(id); err != nil {
+				t.Error(err)
+			}
+		}(id)
+	}
+	wg.Wait()
+
+	for _, id := range ids {
+		if found, err := archive.Contains(id); err != nil || !found {
+			t.Errorf("%s not found in archive", id)
+		}
+	}
+
+	raw, _ := ioutil.ReadFile(archive.filename)
+	if lines := strings.Split(strings.TrimSpace(string(raw)), "\n"); len(lines) != 3 || !strings.HasPrefix(lines[0], "youtube ") {
+		t.Errorf("unexpected archive content %q", raw)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// Audio codec stored by each extracted audio format
+var audioFormatCodecs = map[string]string{
+	"m4a":  "aac",
+	"opus": "opus",
+	"ogg":  "vorbis",
+}
+
+// Select the best audio only format, by bitrate
+// Any codec is accepted when audio format is "best"
+func SelectAudioFormat(formats Formats, audioFormat string) (Format, error) {
+
+	codec := audioFormatCodecs[audioFormat]
+
+	var best Format
+	found := false
+	for _, format := range formats {
+		if format.Acodec == "" || format.Vcodec != "" {
+			continue
+		}
+		if codec != "" && format.Acodec != codec {
+			continue
+		}
+		if !found || format.Abr > best.Abr || (format.Abr == best.Abr && format.Tbr > best.Tbr) {
+			best = format
+			found = true
+		}
+	}
+
+	if !found {
+		return best, fmt.Errorf("no %s audio format available", audioFormat)
+	}
+
+	return best, nil
+}
+
+// Remux audio of a downloaded DASH audio file into a standalone file
+// Nothing is transcoded, so the container follows the codec
+// Return the new filename
+func ExtractAudio(filename string, format Format, audioFormat string, meta Metadata) (string, error) {
+
+	if format.Vcodec != "" {
+		return filename, fmt.Errorf("format %d is not audio only", format.FormatId)
+	}
+
+	var ext string
+	var extract func(src string, dest string, meta Metadata) error
+	switch {
+	case format.Acodec == "aac" && format.Ext == "m4a":
+		ext, extract = "m4a", RemuxM4A
+	case format.Acodec == "opus" && format.Ext == "webm":
+		ext, extract = "opus", ExtractOpus
+	case format.Acodec == "vorbis" && format.Ext == "webm":
+		ext, extract = "ogg", ExtractVorbis
+	default:
+		return filename, fmt.Errorf("can't extract %s audio from format %d", format.Acodec, format.FormatId)
+	}
+
+	if audioFormat != "best" && audioFormat != ext {
+		return filename, fmt.Errorf("can't remux %s audio to %s without transcoding", format.Acodec, audioFormat)
+	}
+
+	dest := replaceExt(filename, ext)
+	if err := extract(filename, dest, meta); err != nil {
+		return filename, err
+	}
+
+	// m4a are remuxed in place
+	if dest != filename {
+		if err := os.Remove(filename); err != nil {
+			return dest, err
+		}
+	}
+
+	return dest, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+)
+
+// Read urls of a batch file one per line, "-" reads stdin
+// Lines are streamed to fn with their number, blank lines and comments (#, ; or ]) are ignored
+func readBatchFile(filename string, fn func(videoUrl string, line int)) error {
+
+	var r io.Reader = os.Stdin
+	if filename != "-" {
+		f, err := os.Open(filename)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+
+		videoUrl := scanner.Text()
+		if line == 1 {
+			videoUrl = strings.TrimPrefix(videoUrl, "\ufeff")
+		}
+		videoUrl = strings.TrimSpace(videoUrl)
+
+		if videoUrl == "" || strings.ContainsAny(videoUrl[:1], "#;]") {
+			continue
+		}
+		fn(videoUrl, line)
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadBatchFile(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gotubedl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "batch.txt")
+	content := "\ufeff" + videoId + "\r\n\n# comment\n; comment\n] comment\n  https://youtu.be/9bZkp7q19f0  \n"
+	if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var urls []string
+	var lines []int
+	err = readBatchFile(filename, func(videoUrl string, line int) {
+		urls = append(urls, videoUrl)
+		lines = append(lines, line)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(urls) != 2 || urls[0] != videoId || urls[1] != "https://youtu.be/9bZkp7q19f0" {
+		t.Fatalf("unexpected urls %q", urls)
+	}
+	if lines[0] != 1 || lines[1] != 6 {
+		t.Errorf("unexpected line numbers %v", lines)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default template of chapter files
+const DefaultChapterTemplate = "%(title)s - %(chapter_number)03d %(chapter)s.%(ext)s"
+
+// Chapter of a video, times are in seconds
+type Chapter struct {
+	Title     string  `json:"title"`
+	StartTime float64 `json:"start_time"`
+	EndTime   float64 `json:"end_time"`
+}
+
+// Start and end of the chapter as durations
+func (c Chapter) Start() time.Duration {
+	return time.Duration(c.StartTime * float64(time.Second))
+}
+
+func (c Chapter) End() time.Duration {
+	return time.Duration(c.EndTime * float64(time.Second))
+}
+
+// Match description lines starting with a timestamp: "1:23 Title", "(01:02:03) - Title"
+var regChapterStart = regexp.MustCompile(`^[\s\-*•▶]*\(?((?:\d+:)?\d{1,2}:\d{2})\)?\s*[\-–—:|.]?\s*(.*?)\s*$`)
+
+// Match description lines ending with a timestamp: "Title - 1:23"
+var regChapterEnd = regexp.MustCompile(`^[\s\-*•▶]*(.*?)\s*[\-–—:|]?\s*\(?((?:\d+:)?\d{1,2}:\d{2})\)?\s*$`)
+
+// Parse h:mm:ss or mm:ss in seconds
+func parseChapterTimestamp(timestamp string) float64 {
+	var seconds int
+	for _, part := range strings.Split(timestamp, ":") {
+		seconds = seconds*60 + itoa(part)
+	}
+	return float64(seconds)
+}
+
+// Extract chapters from timestamps of the video description
+// Like youtube, the first chapter must start at 0:00 and chapters must be in order
+func ParseDescriptionChapters(description string, duration float64) []Chapter {
+
+	var chapters []Chapter
+	for _, line := range strings.Split(description, "\n") {
+
+		var timestamp, title string
+		if match := regChapterStart.FindStringSubmatch(line); match != nil {
+			timestamp, title = match[1], match[2]
+		} else if match := regChapterEnd.FindStringSubmatch(line); match != nil {
+			timestamp, title = match[2], match[1]
+		} else {
+			continue
+		}
+
+		start := parseChapterTimestamp(timestamp)
+		if len(chapters) == 0 && start != 0 {
+			return nil
+		}
+		if len(chapters) > 0 && start <= chapters[len(chapters)-1].StartTime {
+			return nil
+		}
+		if duration > 0 && start >= duration {
+			break
+		}
+		chapters = append(chapters, Chapter{Title: title, StartTime: start})
+	}
+
+	// A single chapter is just the video
+	if len(chapters) < 2 {
+		return nil
+	}
+
+	return chapterEnds(chapters, duration)
+}
+
+// Chapters end when the next one starts, the last one with the video
+// The last chapter is left open, with a zero end time, when the duration is unknown
+func chapterEnds(chapters []Chapter, duration float64) []Chapter {
+	for i := range chapters {
+		if i+1 < len(chapters) {
+			chapters[i].EndTime = chapters[i+1].StartTime
+		} else if duration > chapters[i].StartTime {
+			chapters[i].EndTime = duration
+		}
+		if chapters[i].Title == "" {
+			chapters[i].Title = "Chapter " + strconv.Itoa(i+1)
+		}
+	}
+	return chapters
+}
+
+// Chapters markers of the player, part of the watch page initial data
+type initialData struct {
+	PlayerOverlays struct {
+		PlayerOverlayRenderer struct {
+			DecoratedPlayerBarRenderer struct {
+				DecoratedPlayerBarRenderer struct {
+					PlayerBar struct {
+						MultiMarkersPlayerBarRenderer struct {
+							MarkersMap []struct {
+								Key   string `json:"key"`
+								Value struct {
+									Chapters []struct {
+										ChapterRenderer struct {
+											Title struct {
+												SimpleText string `json:"simpleText"`
+											} `json:"title"`
+											TimeRangeStartMillis int64 `json:"timeRangeStartMillis"`
+										} `json:"chapterRenderer"`
+									} `json:"chapters"`
+								} `json:"value"`
+							} `json:"markersMap"`
+						} `json:"multiMarkersPlayerBarRenderer"`
+					} `json:"playerBar"`
+				} `json:"decoratedPlayerBarRenderer"`
+			} `json:"decoratedPlayerBarRenderer"`
+		} `json:"playerOverlayRenderer"`
+	} `json:"playerOverlays"`
+}
+
+// Extract chapters from the ytInitialData object of a watch page
+func ParsePlayerChapters(page string, duration float64) ([]Chapter, error) {
+
+	start := strings.Index(page, "ytInitialData")
+	if start < 0 {
+		return nil, fmt.Errorf("no initial data found")
+	}
+	object := strings.Index(page[start:], "{")
+	if object < 0 {
+		return nil, fmt.Errorf("no initial data found")
+	}
+	start += object
+
+	// Decoder stops at the end of the object
+	var data initialData
+	if err := json.NewDecoder(strings.NewReader(page[start:])).Decode(&data); err != nil {
+		return nil, err
+	}
+
+	var chapters []Chapter
+	bar := data.PlayerOverlays.PlayerOverlayRenderer.DecoratedPlayerBarRenderer.DecoratedPlayerBarRenderer.PlayerBar
+	for _, marker := range bar.MultiMarkersPlayerBarRenderer.MarkersMap {
+		for _, chapter := range marker.Value.Chapters {
+			chapters = append(chapters, Chapter{
+				Title:     chapter.ChapterRenderer.Title.SimpleText,
+				StartTime: float64(chapter.ChapterRenderer.TimeRangeStartMillis) / 1000,
+			})
+		}
+		if len(chapters) > 0 {
+			break
+		}
+	}
+
+	return chapterEnds(chapters, duration), nil
+}
+
+// Download the watch page to get chapters of the player
+func GetPlayerChapters(ctx context.Context, videoId string, duration float64) ([]Chapter, error) {
+	page, err := downloadPage(ctx, "https://www.youtube.com/watch?v="+videoId)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePlayerChapters(page, duration)
+}
+
+// Split a downloaded file in one file per chapter
+// Return the list of written files
+func SplitChapters(video *Video, format Format, filename string, out io.Writer) ([]string, error) {
+
+	if len(video.Chapters) == 0 {
+		return nil, fmt.Errorf("no chapters found")
+	}
+
+	// Extension may have changed during post processing
+	ext := strings.TrimPrefix(filepath.Ext(filename), ".")
+	format.Ext = ext
+
+	var files []string
+	for i, chapter := range video.Chapters {
+		files = append(files, BuildChapterFilename(opts.ChapterOutput, video, format, chapter, i+1))
+	}
+
+	var err error
+	switch ext {
+	case "mp4", "m4a":
+		err = SplitMP4(filename, video.Chapters, files)
+	case "webm", "mkv":
+		err = SplitMatroska(filename, video.Chapters, files)
+	default:
+		err = fmt.Errorf("can't split %s files", ext)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !opts.Json {
+		for _, file := range files {
+			fmt.Fprintln(out, "Chapter saved to", file)
+		}
+	}
+
+	return files, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import "testing"
+
+func TestParseDescriptionChapters(t *testing.T) {
+
+	description := "Tracklist:\n0:00 Intro\n1:30 - Verse\n(3:05) Chorus\nOutro 4:10\n\nThanks for watching"
+	chapters := ParseDescriptionChapters(description, 300)
+
+	expected := []Chapter{
+		{Title: "Intro", StartTime: 0, EndTime: 90},
+		{Title: "Verse", StartTime: 90, EndTime: 185},
+		{Title: "Chorus", StartTime: 185, EndTime: 250},
+		{Title: "Outro", StartTime: 250, EndTime: 300},
+	}
+	if len(chapters) != len(expected) {
+		t.Fatalf("expected %d chapters, got %v", len(expected), chapters)
+	}
+	for i, chapter := range chapters {
+		if chapter != expected[i] {
+			t.Errorf("expected %v, got %v", expected[i], chapter)
+		}
+	}
+
+	// First chapter must start at 0:00
+	if chapters := ParseDescriptionChapters("1:00 Verse\n2:00 Chorus", 300); chapters != nil {
+		t.Errorf("expected no chapters, got %v", chapters)
+	}
+}
+
+func TestParsePlayerChapters(t *testing.T) {
+
+	page := `<script>var ytInitialData = {"playerOverlays":{"playerOverlayRenderer":{"decoratedPlayerBarRenderer":{"decoratedPlayerBarRenderer":{"playerBar":{"multiMarkersPlayerBarRenderer":{"markersMap":[{"key":"DESCRIPTION_CHAPTERS","value":{"chapters":[` +
+		`{"chapterRenderer":{"title":{"simpleText":"Intro"},"timeRangeStartMillis":0}},` +
+		`{"chapterRenderer":{"title":{"simpleText":"Song"},"timeRangeStartMillis":12500}}]}}]}}}}}}};</script>`
+
+	chapters, err := ParsePlayerChapters(page, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chapters) != 2 || chapters[1].Title != "Song" || chapters[1].StartTime != 12.5 || chapters[0].EndTime != 12.5 || chapters[1].EndTime != 60 {
+		t.Errorf("unexpected chapters %v", chapters)
+	}
+}
+
+func TestChaptersUnknownDuration(t *testing.T) {
+
+	// Last chapter is left open without length_seconds
+	chapters := ParseDescriptionChapters("0:00 Intro\n1:30 Verse", 0)
+	if len(chapters) != 2 || chapters[0].EndTime != 90 || chapters[1].EndTime != 0 {
+		t.Fatalf("unexpected chapters %v", chapters)
+	}
+
+	edition := matroskaChapters(chapters).Child(mkvEditionEntry)
+	atoms := edition.ChildrenById(mkvChapterAtom)
+	if len(atoms) != 2 || atoms[0].Child(mkvChapterTimeEnd) == nil || atoms[1].Child(mkvChapterTimeEnd) != nil {
+		t.Errorf("expected only the first chapter to have an end")
+	}
+}
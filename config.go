@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// Location of the config file when none is given
+// ~/.config/gotubedl/config, following XDG_CONFIG_HOME
+func defaultConfigLocation() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home := os.Getenv("HOME")
+		if home == "" {
+			home = os.Getenv("USERPROFILE")
+		}
+		if home == "" {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "gotubedl", "config")
+}
+
+// Value of a long flag given as --name value or --name=value, last one wins
+// Config options must be known before parsing all flags
+func findFlag(args []string, name string) string {
+	var value string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			break
+		}
+		if arg == "--"+name && i+1 < len(args) {
+			value = args[i+1]
+			i++
+		} else if strings.HasPrefix(arg, "--"+name+"=") {
+			value = strings.TrimPrefix(arg, "--"+name+"=")
+		}
+	}
+	return value
+}
+
+// Parse a config file into flags
+// Lines are long flag names, with or without dashes, and an optional value: "format = 22"
+// Lines before any section always apply, sections only when selected by profile
+func ParseConfig(r io.Reader, profile string) ([]string, error) {
+
+	var args []string
+	var section string
+	sections := map[string]bool{}
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") || strings.HasPrefix(text, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(text, "[") {
+			if !strings.HasSuffix(text, "]") {
+				return nil, fmt.Errorf("line %d: invalid section %s", line, text)
+			}
+			section = strings.TrimSpace(text[1 : len(text)-1])
+			sections[section] = true
+			continue
+		}
+		if section != "" && section != profile {
+			continue
+		}
+
+		name, value := text, ""
+		hasValue := false
+		if i := strings.IndexAny(text, "= \t"); i >= 0 {
+			name = text[:i]
+			value = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(text[i:]), "="))
+			hasValue = true
+		}
+		name = strings.TrimLeft(name, "-")
+		if name == "" {
+			return nil, fmt.Errorf("line %d: missing option name", line)
+		}
+
+		// Quotes keep surrounding spaces
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+
+		switch {
+		case !hasValue || value == "true":
+			args = append(args, "--"+name)
+		case value == "false":
+			// Turn off a flag set by common options
+			args = append(args, "--no-"+name)
+		default:
+			args = append(args, "--"+name+"="+value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if profile != "" && !sections[profile] {
+		return nil, fmt.Errorf("profile %s not found", profile)
+	}
+
+	return args, nil
+}
+
+// Long name of a flag argument, short names are resolved with longNames
+func flagName(arg string, longNames map[string]string) string {
+	switch {
+	case strings.HasPrefix(arg, "--"):
+		return strings.SplitN(arg[2:], "=", 2)[0]
+	case strings.HasPrefix(arg, "-") && len(arg) > 1:
+		return longNames[arg[1:2]]
+	}
+	return ""
+}
+
+// Split bundled short flags like -xv, a flag taking a value ends the bundle (-xo file, -xofile)
+// Values of the flags are kept as they are
+func expandShortFlags(args []string, kinds map[string]reflect.Kind, longNames map[string]string) []string {
+
+	var expanded []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return append(expanded, args[i:]...)
+		}
+
+		// Flags taking a value have it in the next arg unless given inline
+		flags, takesValue := []string{arg}, false
+		switch {
+		case strings.HasPrefix(arg, "--"):
+			name := flagName(arg, longNames)
+			_, known := kinds[name]
+			takesValue = known && kinds[name] != reflect.Bool && !strings.Contains(arg, "=")
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			var bundle []string
+			for j := 1; j < len(arg); j++ {
+				long, ok := longNames[arg[j:j+1]]
+				if !ok {
+					// Unknown flags are left to the parser
+					bundle, takesValue = []string{arg}, false
+					break
+				}
+				if kinds[long] != reflect.Bool {
+					bundle = append(bundle, "-"+arg[j:])
+					takesValue = j == len(arg)-1
+					break
+				}
+				bundle = append(bundle, "-"+arg[j:j+1])
+			}
+			flags = bundle
+		}
+
+		expanded = append(expanded, flags...)
+		if takesValue && i+1 < len(args) {
+			i++
+			expanded = append(expanded, args[i])
+		}
+	}
+	return expanded
+}
+
+// Combine config and command line flags, command line flags come last to override the config
+// Repeatable options given on the command line replace the config ones
+// Boolean options are turned off with --no-name
+func mergeArgs(configArgs []string, cliArgs []string) ([]string, error) {
+
+	kinds := map[string]reflect.Kind{}
+	longNames := map[string]string{}
+	options := reflect.TypeOf(Options{})
+	for i := 0; i < options.NumField(); i++ {
+		field := options.Field(i)
+		long := field.Tag.Get("long")
+		kinds[long] = field.Type.Kind()
+		if short := field.Tag.Get("short"); short != "" {
+			longNames[short] = long
+		}
+	}
+	configArgs = expandShortFlags(configArgs, kinds, longNames)
+	cliArgs = expandShortFlags(cliArgs, kinds, longNames)
+
+	given := map[string]bool{}
+	for _, arg := range cliArgs {
+		if arg == "--" {
+			break
+		}
+		given[flagName(arg, longNames)] = true
+	}
+
+	var args []string
+	for _, arg := range configArgs {
+		if name := flagName(arg, longNames); kinds[name] == reflect.Slice && given[name] {
+			continue
+		}
+		args = append(args, arg)
+	}
+
+	// Negated flags remove the previous ones, they are unknown to the parser
+	all := append(args, cliArgs...)
+	var merged []string
+	for i, arg := range all {
+		if arg == "--" {
+			merged = append(merged, all[i:]...)
+			break
+		}
+		name := strings.TrimPrefix(arg, "--no-")
+		kind, known := kinds[name]
+		if name == arg || !known {
+			merged = append(merged, arg)
+			continue
+		}
+		if kind != reflect.Bool {
+			return nil, fmt.Errorf("%s: only boolean options can be turned off, --%s takes a value", arg, name)
+		}
+		var kept []string
+		for _, previous := range merged {
+			if previous != "--"+name && !(len(previous) == 2 && longNames[previous[1:]] == name) {
+				kept = append(kept, previous)
+			}
+		}
+		merged = kept
+	}
+
+	return merged, nil
+}
+
+// Flags of the config file selected by the command line
+// A missing default config file is fine, a missing given one is not
+func loadConfig(args []string) ([]string, error) {
+
+	location := findFlag(args, "config-location")
+	profile := findFlag(args, "profile")
+	explicit := location != ""
+	if !explicit {
+		location = defaultConfigLocation()
+	}
+
+	f, err := os.Open(location)
+	if err != nil {
+		if !explicit && os.IsNotExist(err) {
+			if profile != "" {
+				return nil, fmt.Errorf("profile %s not found, no config file", profile)
+			}
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	configArgs, err := ParseConfig(f, profile)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", location, err)
+	}
+	return configArgs, nil
+}
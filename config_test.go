@@ -0,0 +1,86 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testConfig = `# Common options
+output = "%(title)s-%(id)s.%(ext)s"
+--retries 5
+embed-metadata
+write-thumbnail = false
+
+[audio]
+extract-audio = true
+audio-format = m4a
+
+; other profile
+[archive]
+download-archive = archive.txt
+`
+
+func TestParseConfig(t *testing.T) {
+
+	tests := map[string][]string{
+		"":        {"--output=%(title)s-%(id)s.%(ext)s", "--retries=5", "--embed-metadata", "--no-write-thumbnail"},
+		"audio":   {"--output=%(title)s-%(id)s.%(ext)s", "--retries=5", "--embed-metadata", "--no-write-thumbnail", "--extract-audio", "--audio-format=m4a"},
+		"archive": {"--output=%(title)s-%(id)s.%(ext)s", "--retries=5", "--embed-metadata", "--no-write-thumbnail", "--download-archive=archive.txt"},
+	}
+	for profile, expected := range tests {
+		args, err := ParseConfig(strings.NewReader(testConfig), profile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(args, expected) {
+			t.Errorf("%q: expected %q, got %q", profile, expected, args)
+		}
+	}
+
+	if _, err := ParseConfig(strings.NewReader(testConfig), "video"); err == nil {
+		t.Errorf("expected error for unknown profile")
+	}
+	if _, err := ParseConfig(strings.NewReader("[audio\n"), ""); err == nil {
+		t.Errorf("expected error for invalid section")
+	}
+}
+
+func TestFindFlag(t *testing.T) {
+
+	args := []string{"--profile", "audio", "-x", "--config-location=a.conf", "--profile=video", "--", "--profile=url"}
+	if value := findFlag(args, "profile"); value != "video" {
+		t.Errorf("expected video, got %q", value)
+	}
+	if value := findFlag(args, "config-location"); value != "a.conf" {
+		t.Errorf("expected a.conf, got %q", value)
+	}
+	if value := findFlag(args, "output"); value != "" {
+		t.Errorf("expected no value, got %q", value)
+	}
+}
+
+func TestMergeArgs(t *testing.T) {
+
+	config := []string{"--embed-metadata", "--print=title", "--add-header=Referer:a", "--no-write-thumbnail", "--retries=5"}
+	cli := []string{"-O", "id", "--no-embed-metadata", "-x", "--retries=2", "url", "--", "--no-x"}
+
+	expected := []string{"--add-header=Referer:a", "--retries=5", "-O", "id", "-x", "--retries=2", "url", "--", "--no-x"}
+	if args, err := mergeArgs(config, cli); err != nil || !reflect.DeepEqual(args, expected) {
+		t.Errorf("expected %q, got %q %v", expected, args, err)
+	}
+
+	// Short flags are turned off too, bundled ones included
+	if args, err := mergeArgs(nil, []string{"-x", "--no-extract-audio"}); err != nil || len(args) != 0 {
+		t.Errorf("expected no args, got %q %v", args, err)
+	}
+	expected = []string{"-v", "-o", "%(id)s", "-O", "title", "-i", "-ofile", "--", "-xv"}
+	if args, err := mergeArgs([]string{"-xvo", "%(id)s"}, []string{"-xO", "title", "-iofile", "--no-extract-audio", "--", "-xv"}); err != nil || !reflect.DeepEqual(args, expected) {
+		t.Errorf("expected %q, got %q %v", expected, args, err)
+	}
+
+	// Options taking a value can't be turned off
+	if _, err := mergeArgs([]string{"--retries=5"}, []string{"--no-retries"}); err == nil {
+		t.Errorf("expected error for --no-retries")
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Prefix of http only cookies in cookies.txt files
+const httpOnlyPrefix = "#HttpOnly_"
+
+// Cookie stored in the jar
+// Host only cookies match their domain exactly, others match subdomains too
+type jarCookie struct {
+	Domain   string
+	HostOnly bool
+	Path     string
+	Secure   bool
+	HttpOnly bool
+	Expires  time.Time
+	Name     string
+	Value    string
+}
+
+// Session cookies have no expiration date
+func (c *jarCookie) expired(now time.Time) bool {
+	return !c.Expires.IsZero() && !c.Expires.After(now)
+}
+
+func (c *jarCookie) matches(u *url.URL) bool {
+
+	host := strings.ToLower(u.Hostname())
+	if c.HostOnly && host != c.Domain {
+		return false
+	}
+	if !c.HostOnly && host != c.Domain && !strings.HasSuffix(host, "."+c.Domain) {
+		return false
+	}
+
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if path != c.Path && !strings.HasPrefix(path, strings.TrimSuffix(c.Path, "/")+"/") {
+		return false
+	}
+
+	return !c.Secure || u.Scheme == "https"
+}
+
+// Cookie jar that can be loaded from and saved to Netscape cookies.txt files
+// Safe for concurrent use
+type CookieJar struct {
+	mu      sync.Mutex
+	cookies []*jarCookie
+	now     func() time.Time
+}
+
+func NewCookieJar() *CookieJar {
+	return &CookieJar{now: time.Now}
+}
+
+// Add or replace a cookie with the same domain, path and name
+func (j *CookieJar) set(cookie *jarCookie) {
+	for i, c := range j.cookies {
+		if c.Domain == cookie.Domain && c.Path == cookie.Path && c.Name == cookie.Name {
+			j.cookies[i] = cookie
+			return
+		}
+	}
+	j.cookies = append(j.cookies, cookie)
+}
+
+// Remove a cookie, expired cookies are deleted that way
+func (j *CookieJar) remove(cookie *jarCookie) {
+	for i, c := range j.cookies {
+		if c.Domain == cookie.Domain && c.Path == cookie.Path && c.Name == cookie.Name {
+			j.cookies = append(j.cookies[:i], j.cookies[i+1:]...)
+			return
+		}
+	}
+}
+
+// Default path of a cookie is the directory of the request path
+func defaultCookiePath(u *url.URL) string {
+	path := u.EscapedPath()
+	if i := strings.LastIndex(path, "/"); i > 0 {
+		return path[:i]
+	}
+	return "/"
+}
+
+// Store cookies received from u, part of http.CookieJar
+func (j *CookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := j.now()
+	host := strings.ToLower(u.Hostname())
+	for _, cookie := range cookies {
+
+		c := &jarCookie{
+			Domain:   host,
+			HostOnly: true,
+			Path:     cookie.Path,
+			Secure:   cookie.Secure,
+			HttpOnly: cookie.HttpOnly,
+			Name:     cookie.Name,
+			Value:    cookie.Value,
+		}
+
+		// Cookies may only be set for the host or one of its parents, never for an ip
+		if domain := strings.TrimPrefix(strings.ToLower(cookie.Domain), "."); domain != "" && domain != host {
+			if net.ParseIP(host) != nil || !strings.HasSuffix(host, "."+domain) || !strings.Contains(domain, ".") {
+				continue
+			}
+			c.Domain = domain
+		}
+		if cookie.Domain != "" {
+			c.HostOnly = false
+		}
+		if !strings.HasPrefix(c.Path, "/") {
+			c.Path = defaultCookiePath(u)
+		}
+
+		switch {
+		case cookie.MaxAge < 0:
+			c.Expires = now
+		case cookie.MaxAge > 0:
+			c.Expires = now.Add(time.Duration(cookie.MaxAge) * time.Second)
+		case !cookie.Expires.IsZero():
+			c.Expires = cookie.Expires
+		}
+
+		if c.expired(now) {
+			j.remove(c)
+		} else {
+			j.set(c)
+		}
+	}
+}
+
+// Cookies to send to u, part of http.CookieJar
+// Longer paths come first
+func (j *CookieJar) Cookies(u *url.URL) []*http.Cookie {
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	now := j.now()
+	var matching []*jarCookie
+	for _, c := range j.cookies {
+		if !c.expired(now) && c.matches(u) {
+			matching = append(matching, c)
+		}
+	}
+
+	// Insertion sort, stable and jars are small
+	for i := 1; i < len(matching); i++ {
+		for k := i; k > 0 && len(matching[k].Path) > len(matching[k-1].Path); k-- {
+			matching[k], matching[k-1] = matching[k-1], matching[k]
+		}
+	}
+
+	var cookies []*http.Cookie
+	for _, c := range matching {
+		cookies = append(cookies, &http.Cookie{Name: c.Name, Value: c.Value})
+	}
+	return cookies
+}
+
+// Read cookies from a Netscape cookies.txt file
+// Fields are domain, include subdomains, path, secure, expiration, name and value
+func (j *CookieJar) Load(r io.Reader) error {
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+
+		text := strings.TrimRight(scanner.Text(), "\r")
+		httpOnly := strings.HasPrefix(text, httpOnlyPrefix)
+		text = strings.TrimPrefix(text, httpOnlyPrefix)
+		if strings.TrimSpace(text) == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Split(text, "\t")
+		if len(fields) != 7 {
+			return fmt.Errorf("invalid cookie line %d: expected 7 fields, got %d", line, len(fields))
+		}
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid cookie line %d: %v", line, err)
+		}
+
+		c := &jarCookie{
+			Domain:   strings.TrimPrefix(strings.ToLower(fields[0]), "."),
+			HostOnly: strings.ToUpper(fields[1]) != "TRUE",
+			Path:     fields[2],
+			Secure:   strings.ToUpper(fields[3]) == "TRUE",
+			HttpOnly: httpOnly,
+			Name:     fields[5],
+			Value:    fields[6],
+		}
+		if expires > 0 {
+			c.Expires = time.Unix(expires, 0)
+		}
+		if c.Path == "" {
+			c.Path = "/"
+		}
+		j.set(c)
+	}
+
+	return scanner.Err()
+}
+
+// Write cookies in Netscape cookies.txt format, expired ones are dropped
+func (j *CookieJar) Save(w io.Writer) error {
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	flag := func(b bool) string {
+		if b {
+			return "TRUE"
+		}
+		return "FALSE"
+	}
+
+	if _, err := fmt.Fprint(w, "# Netscape HTTP Cookie File\n\n"); err != nil {
+		return err
+	}
+
+	now := j.now()
+	for _, c := range j.cookies {
+		if c.expired(now) {
+			continue
+		}
+
+		domain := c.Domain
+		if !c.HostOnly {
+			domain = "." + domain
+		}
+		if c.HttpOnly {
+			domain = httpOnlyPrefix + domain
+		}
+		var expires int64
+		if !c.Expires.IsZero() {
+			expires = c.Expires.Unix()
+		}
+
+		_, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			domain, flag(!c.HostOnly), c.Path, flag(c.Secure), expires, c.Name, c.Value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Load a cookies file, missing files give an empty jar
+func loadCookieFile(filename string) (*CookieJar, error) {
+
+	jar := NewCookieJar()
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return jar, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if err := jar.Load(f); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return jar, nil
+}
+
+// Save a jar to a cookies file, replaced atomically
+// Cookies hold sessions, the mode of the file is kept and new files are private
+func saveCookieFile(jar *CookieJar, filename string) error {
+	mode := os.FileMode(0600)
+	if stat, err := os.Stat(filename); err == nil {
+		mode = stat.Mode().Perm()
+	}
+	return writeFileAtomic(filename, func(f *os.File) error {
+		if err := f.Chmod(mode); err != nil {
+			return err
+		}
+		return jar.Save(f)
+	})
+}
+
+// Jar of the cookies option, nil when unused
+var cookieJar *CookieJar
+
+// Keep cookies refreshed by youtube when requested
+func saveCookies() {
+	if cookieJar != nil && opts.SaveCookies {
+		if err := saveCookieFile(cookieJar, opts.Cookies); err != nil {
+			fmt.Fprintln(os.Stderr, "Unable to save cookies:", err)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testCookies = `# Netscape HTTP Cookie File
+# comment
+
+.youtube.com	TRUE	/	TRUE	1893456000	LOGIN_INFO	secret
+#HttpOnly_.youtube.com	TRUE	/	FALSE	0	PREF	f1=50000000
+www.youtube.com	FALSE	/watch	FALSE	1893456000	VISITOR	abc
+.youtube.com	TRUE	/	FALSE	946684800	OLD	gone
+`
+
+func cookieNames(cookies []*http.Cookie) string {
+	var names []string
+	for _, cookie := range cookies {
+		names = append(names, cookie.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestCookieJarLoad(t *testing.T) {
+
+	jar := NewCookieJar()
+	jar.now = func() time.Time { return time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC) }
+	if err := jar.Load(strings.NewReader(testCookies)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"https://www.youtube.com/watch?v=dQw4w9WgXcQ": "VISITOR,LOGIN_INFO,PREF",
+		"http://www.youtube.com/watch":                "VISITOR,PREF",
+		"https://m.youtube.com/":                      "LOGIN_INFO,PREF",
+		"https://youtube.com/watchlater":              "LOGIN_INFO,PREF",
+		"https://example.com/":                        "",
+	}
+	for rawUrl, expected := range tests {
+		u, _ := url.Parse(rawUrl)
+		if names := cookieNames(jar.Cookies(u)); names != expected {
+			t.Errorf("%s: expected %q, got %q", rawUrl, expected, names)
+		}
+	}
+
+	if err := jar.Load(strings.NewReader("youtube.com\tTRUE\t/\n")); err == nil {
+		t.Errorf("expected error for truncated line")
+	}
+}
+
+func TestCookieJarSetCookies(t *testing.T) {
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	jar := NewCookieJar()
+	jar.now = func() time.Time { return now }
+	if err := jar.Load(strings.NewReader(testCookies)); err != nil {
+		t.Fatal(err)
+	}
+
+	u, _ := url.Parse("https://www.youtube.com/watch?v=dQw4w9WgXcQ")
+	jar.SetCookies(u, []*http.Cookie{
+		{Name: "LOGIN_INFO", Value: "refreshed", Domain: ".youtube.com", Path: "/", MaxAge: 3600, Secure: true},
+		{Name: "PREF", Domain: "youtube.com", Path: "/", MaxAge: -1},
+		{Name: "YSC", Value: "session", HttpOnly: true},
+		{Name: "EVIL", Value: "x", Domain: "example.com"},
+	})
+
+	var buf bytes.Buffer
+	if err := jar.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := "# Netscape HTTP Cookie File\n\n" +
+		".youtube.com\tTRUE\t/\tTRUE\t1577840400\tLOGIN_INFO\trefreshed\n" +
+		"www.youtube.com\tFALSE\t/watch\tFALSE\t1893456000\tVISITOR\tabc\n" +
+		"#HttpOnly_www.youtube.com\tFALSE\t/\tFALSE\t0\tYSC\tsession\n"
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+
+	// Saved file loads back the same
+	reloaded := NewCookieJar()
+	reloaded.now = jar.now
+	if err := reloaded.Load(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if names := cookieNames(reloaded.Cookies(u)); names != "VISITOR,LOGIN_INFO,YSC" {
+		t.Errorf("unexpected reloaded cookies %q", names)
+	}
+}
+
+func TestSaveCookieFileMode(t *testing.T) {
+
+	if runtime.GOOS == "windows" {
+		t.Skip("no unix file modes")
+	}
+
+	dir, err := ioutil.TempDir("", "gotubedl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// New files are private, existing ones keep their mode
+	filename := filepath.Join(dir, "cookies.txt")
+	for _, mode := range []os.FileMode{0600, 0640} {
+		if err := saveCookieFile(NewCookieJar(), filename); err != nil {
+			t.Fatal(err)
+		}
+		if stat, err := os.Stat(filename); err != nil || stat.Mode().Perm() != mode {
+			t.Errorf("expected mode %v, got %v %v", mode, stat.Mode().Perm(), err)
+		}
+		os.Chmod(filename, 0640)
+	}
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// Matroska element ids, marker bits included
+const (
+	mkvEBML               = 0x1A45DFA3
+	mkvSegment            = 0x18538067
+	mkvSeekHead           = 0x114D9B74
+	mkvSeek               = 0x4DBB
+	mkvSeekId             = 0x53AB
+	mkvSeekPosition       = 0x53AC
+	mkvInfo               = 0x1549A966
+	mkvTimecodeScale      = 0x2AD7B1
+	mkvDuration           = 0x4489
+	mkvTitle              = 0x7BA9
+	mkvTracks             = 0x1654AE6B
+	mkvTrackEntry         = 0xAE
+	mkvTrackNumber        = 0xD7
+	mkvTrackUID           = 0x73C5
+	mkvTrackType          = 0x83
+	mkvCodecId            = 0x86
+	mkvCodecPrivate       = 0x63A2
+	mkvCodecDelay         = 0x56AA
+	mkvLanguage           = 0x22B59C
+	mkvLanguageBCP47      = 0x22B59D
+	mkvName               = 0x536E
+	mkvFlagDefault        = 0x88
+	mkvFlagLacing         = 0x9C
+	mkvAudio              = 0xE1
+	mkvSamplingFrequency  = 0xB5
+	mkvChannels           = 0x9F
+	mkvVideo              = 0xE0
+	mkvCues               = 0x1C53BB6B
+	mkvCuePoint           = 0xBB
+	mkvCueTime            = 0xB3
+	mkvCueTrackPositions  = 0xB7
+	mkvCueTrack           = 0xF7
+	mkvCueClusterPosition = 0xF1
+	mkvCluster            = 0x1F43B675
+	mkvTimecode           = 0xE7
+	mkvSimpleBlock        = 0xA3
+	mkvBlockGroup         = 0xA0
+	mkvBlock              = 0xA1
+	mkvBlockDuration      = 0x9B
+	mkvReferenceBlock     = 0xFB
+	mkvTags               = 0x1254C367
+	mkvTag                = 0x7373
+	mkvTargets            = 0x63C0
+	mkvTargetTypeValue    = 0x68CA
+	mkvSimpleTag          = 0x67C8
+	mkvTagName            = 0x45A3
+	mkvTagString          = 0x4487
+	mkvAttachments        = 0x1941A469
+	mkvAttachedFile       = 0x61A7
+	mkvFileDescription    = 0x467E
+	mkvFileName           = 0x466E
+	mkvFileMimeType       = 0x4660
+	mkvFileData           = 0x465C
+	mkvFileUID            = 0x46AE
+	mkvChapters           = 0x1043A770
+	mkvEditionEntry       = 0x45B9
+	mkvEditionUID         = 0x45BC
+	mkvChapterAtom        = 0xB6
+	mkvChapterUID         = 0x73C4
+	mkvChapterTimeStart   = 0x91
+	mkvChapterTimeEnd     = 0x92
+	mkvChapterDisplay     = 0x80
+	mkvChapString         = 0x85
+	mkvChapLanguage       = 0x437C
+	mkvVoid               = 0xEC
+	mkvCRC32              = 0xBF
+)
+
+// Elements containing other elements
+var ebmlMasters = map[uint32]bool{
+	mkvEBML: true, mkvSegment: true, mkvSeekHead: true, mkvSeek: true,
+	mkvInfo: true, mkvTracks: true, mkvTrackEntry: true, mkvAudio: true,
+	mkvVideo: true, mkvCues: true, mkvCuePoint: true, mkvCueTrackPositions: true,
+	mkvCluster: true, mkvBlockGroup: true, mkvTags: true, mkvTag: true,
+	mkvTargets: true, mkvSimpleTag: true, mkvAttachments: true,
+	mkvAttachedFile: true, mkvChapters: true, mkvEditionEntry: true,
+	mkvChapterAtom: true, mkvChapterDisplay: true,
+}
+
+// Size of elements streamed without knowing their length
+const ebmlUnknownSize = math.MaxUint64
+
+// Matroska element (EBML)
+type Element struct {
+	Id       uint32
+	Data     []byte
+	Children []*Element
+}
+
+// Read an element id, marker bit is part of the id
+func readElementId(data []byte) (uint32, int, error) {
+	if len(data) == 0 || data[0] == 0 {
+		return 0, 0, fmt.Errorf("invalid element id")
+	}
+	length := bits.LeadingZeros8(data[0]) + 1
+	if length > 4 || len(data) < length {
+		return 0, 0, fmt.Errorf("invalid element id")
+	}
+	var id uint32
+	for _, b := range data[:length] {
+		id = id<<8 | uint32(b)
+	}
+	return id, length, nil
+}
+
+// Read a variable size integer, marker bit is removed
+// All bits set means the size is unknown
+func readVint(data []byte) (uint64, int, error) {
+	if len(data) == 0 || data[0] == 0 {
+		return 0, 0, fmt.Errorf("invalid vint")
+	}
+	length := bits.LeadingZeros8(data[0]) + 1
+	if len(data) < length {
+		return 0, 0, fmt.Errorf("truncated vint")
+	}
+	value := uint64(data[0]) & (0xff >> uint(length))
+	for _, b := range data[1:length] {
+		value = value<<8 | uint64(b)
+	}
+	if value == 1<<(7*uint(length))-1 {
+		return ebmlUnknownSize, length, nil
+	}
+	return value, length, nil
+}
+
+// Encode a variable size integer on the given number of bytes
+func encodeVintWidth(value uint64, length int) []byte {
+	buf := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		buf[i] = byte(value)
+		value >>= 8
+	}
+	buf[0] |= 0x80 >> uint(length-1)
+	return buf
+}
+
+// Encode a variable size integer on the smallest number of bytes
+func encodeVint(value uint64) []byte {
+	length := 1
+	for length < 8 && value >= 1<<(7*uint(length))-1 {
+		length++
+	}
+	return encodeVintWidth(value, length)
+}
+
+// Encode an element id
+func encodeElementId(id uint32) []byte {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, id)
+	for len(buf) > 1 && buf[0] == 0 {
+		buf = buf[1:]
+	}
+	return buf
+}
+
+// Encode an unsigned integer on the smallest number of bytes
+func encodeUint(value uint64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, value)
+	for len(buf) > 1 && buf[0] == 0 {
+		buf = buf[1:]
+	}
+	return buf
+}
+
+// Parse a sequence of elements
+// Elements of unknown size extend to the end of data
+func ParseElements(data []byte) ([]*Element, error) {
+
+	var elements []*Element
+	for len(data) > 0 {
+
+		id, idLength, err := readElementId(data)
+		if err != nil {
+			return nil, err
+		}
+		size, sizeLength, err := readVint(data[idLength:])
+		if err != nil {
+			return nil, err
+		}
+
+		start := uint64(idLength + sizeLength)
+		if size == ebmlUnknownSize {
+			size = uint64(len(data)) - start
+		}
+		if start+size > uint64(len(data)) {
+			return nil, fmt.Errorf("invalid size for element %x", id)
+		}
+
+		element := &Element{Id: id}
+		payload := data[start : start+size]
+		if ebmlMasters[id] {
+			if element.Children, err = ParseElements(payload); err != nil {
+				return nil, err
+			}
+		} else {
+			element.Data = payload
+		}
+
+		elements = append(elements, element)
+		data = data[start+size:]
+	}
+
+	return elements, nil
+}
+
+// Size of the element data
+func (e *Element) DataSize() uint64 {
+	size := uint64(len(e.Data))
+	for _, child := range e.Children {
+		size += child.Size()
+	}
+	return size
+}
+
+// Size of the element once serialized
+func (e *Element) Size() uint64 {
+	dataSize := e.DataSize()
+	return uint64(len(encodeElementId(e.Id))+len(encodeVint(dataSize))) + dataSize
+}
+
+// Serialize element and its children
+func (e *Element) Bytes() []byte {
+	buf := encodeElementId(e.Id)
+	buf = append(buf, encodeVint(e.DataSize())...)
+	buf = append(buf, e.Data...)
+	for _, child := range e.Children {
+		buf = append(buf, child.Bytes()...)
+	}
+	return buf
+}
+
+// First child with the given id
+func (e *Element) Child(id uint32) *Element {
+	for _, child := range e.Children {
+		if child.Id == id {
+			return child
+		}
+	}
+	return nil
+}
+
+// All children with the given id
+func (e *Element) ChildrenById(id uint32) []*Element {
+	var elements []*Element
+	for _, child := range e.Children {
+		if child.Id == id {
+			elements = append(elements, child)
+		}
+	}
+	return elements
+}
+
+// Deep copy of the element
+func (e *Element) Copy() *Element {
+	copy := &Element{Id: e.Id, Data: append([]byte(nil), e.Data...)}
+	for _, child := range e.Children {
+		copy.Children = append(copy.Children, child.Copy())
+	}
+	return copy
+}
+
+// Replace first child with the same id, or append it
+func (e *Element) SetChild(element *Element) {
+	for i, child := range e.Children {
+		if child.Id == element.Id {
+			e.Children[i] = element
+			return
+		}
+	}
+	e.Children = append(e.Children, element)
+}
+
+// Value of an unsigned integer element
+func (e *Element) Uint() uint64 {
+	var value uint64
+	for _, b := range e.Data {
+		value = value<<8 | uint64(b)
+	}
+	return value
+}
+
+// Value of a float element
+func (e *Element) Float() float64 {
+	switch len(e.Data) {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(e.Data)))
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(e.Data))
+	}
+	return 0
+}
+
+// Unsigned integer child value, or def when missing
+func (e *Element) ChildUint(id uint32, def uint64) uint64 {
+	if child := e.Child(id); child != nil {
+		return child.Uint()
+	}
+	return def
+}
+
+// String child value
+func (e *Element) ChildString(id uint32) string {
+	if child := e.Child(id); child != nil {
+		return string(trimNull(child.Data))
+	}
+	return ""
+}
+
+// Strings may be padded with null bytes
+func trimNull(data []byte) []byte {
+	for len(data) > 0 && data[len(data)-1] == 0 {
+		data = data[:len(data)-1]
+	}
+	return data
+}
+
+// Create an unsigned integer element
+func NewUintElement(id uint32, value uint64) *Element {
+	return &Element{Id: id, Data: encodeUint(value)}
+}
+
+// Create a string element
+func NewStringElement(id uint32, value string) *Element {
+	return &Element{Id: id, Data: []byte(value)}
+}
+
+// Create a float element, stored on 8 bytes
+func NewFloatElement(id uint32, value float64) *Element {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, math.Float64bits(value))
+	return &Element{Id: id, Data: data}
+}
+
+// Create a binary element
+func NewBinaryElement(id uint32, data []byte) *Element {
+	return &Element{Id: id, Data: data}
+}
+
+// Create a master element
+func NewMasterElement(id uint32, children ...*Element) *Element {
+	return &Element{Id: id, Children: children}
+}
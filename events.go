@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Machine readable event of the download of a video
+// Types are extracting, format, progress, postprocess, finished and error
+type Event struct {
+	Type     string         `json:"type"`
+	Time     float64        `json:"time"` // Unix time in seconds
+	VideoId  string         `json:"video_id,omitempty"`
+	Url      string         `json:"url,omitempty"`
+	Playlist *PlaylistItem  `json:"playlist,omitempty"`
+	FormatId int            `json:"format_id,omitempty"`
+	Ext      string         `json:"ext,omitempty"`
+	Filename string         `json:"filename,omitempty"`
+	Progress *ProgressEvent `json:"progress,omitempty"`
+	Step     string         `json:"step,omitempty"`
+	Skipped  bool           `json:"skipped,omitempty"`
+	Reason   string         `json:"reason,omitempty"` // Why the video was skipped
+	Error    string         `json:"error,omitempty"`
+}
+
+// Progress of a download event, sizes in bytes and times in seconds
+type ProgressEvent struct {
+	Downloaded int64    `json:"downloaded_bytes"`
+	Total      int64    `json:"total_bytes,omitempty"`
+	Speed      float64  `json:"speed"`
+	ETA        *float64 `json:"eta,omitempty"`
+}
+
+// Write events as newline delimited JSON, safe for concurrent use
+type EventWriter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	now func() time.Time
+}
+
+func NewEventWriter(w io.Writer) *EventWriter {
+	return &EventWriter{enc: json.NewEncoder(w), now: time.Now}
+}
+
+func (w *EventWriter) Emit(event Event) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	event.Time = float64(w.now().UnixNano()/int64(time.Millisecond)) / 1000
+	return w.enc.Encode(event)
+}
+
+// Writer of the progress-json option, nil when unused
+var eventWriter *EventWriter
+
+// Emit an event when requested, errors are ignored like console output
+func emitEvent(event Event) {
+	if eventWriter != nil {
+		eventWriter.Emit(event)
+	}
+}
+
+// Reporter sending progress events of a video
+type eventProgress struct {
+	videoId string
+}
+
+func (p eventProgress) Update(progress Progress) {
+	event := &ProgressEvent{
+		Downloaded: progress.Downloaded,
+		Total:      progress.Total,
+		Speed:      progress.Speed,
+	}
+	if eta := progress.ETA(); eta >= 0 {
+		seconds := eta.Seconds()
+		event.ETA = &seconds
+	}
+	emitEvent(Event{Type: "progress", VideoId: p.videoId, Filename: progress.Filename, Progress: event})
+}
+
+func (eventProgress) Finish(error) {}
+
+// Reporter forwarding progress to several reporters
+type multiProgress []ProgressReporter
+
+func (m multiProgress) Update(p Progress) {
+	for _, reporter := range m {
+		reporter.Update(p)
+	}
+}
+
+func (m multiProgress) Finish(err error) {
+	for _, reporter := range m {
+		reporter.Finish(err)
+	}
+}
+
+// Open the file descriptor receiving events, stderr by default
+func setupEvents() error {
+
+	if !opts.ProgressJson {
+		return nil
+	}
+
+	w := os.Stderr
+	switch opts.ProgressFd {
+	case 1:
+		if opts.Json {
+			return fmt.Errorf("progress events can't be mixed with json output on stdout")
+		}
+		w = os.Stdout
+	case 2:
+	default:
+		if w = os.NewFile(uintptr(opts.ProgressFd), "progress"); w == nil {
+			return fmt.Errorf("invalid progress file descriptor %d", opts.ProgressFd)
+		}
+	}
+
+	eventWriter = NewEventWriter(w)
+	return nil
+}
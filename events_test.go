@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestEventWriter(t *testing.T) {
+
+	var buf bytes.Buffer
+	w := NewEventWriter(&buf)
+	w.now = func() time.Time { return time.Unix(1500000000, 250*int64(time.Millisecond)) }
+
+	saved := eventWriter
+	defer func() { eventWriter = saved }()
+	eventWriter = w
+
+	emitEvent(Event{Type: "extracting", VideoId: "dQw4w9WgXcQ", Url: "https://youtu.be/dQw4w9WgXcQ"})
+	reporter := newProgressReporter("dQw4w9WgXcQ")
+	reporter.Update(Progress{Filename: "video.mp4", Downloaded: 500, Total: 1000, Speed: 100})
+	reporter.Update(Progress{Filename: "video.mp4"})
+	reporter.Finish(nil)
+	emitEvent(Event{Type: "finished", VideoId: "dQw4w9WgXcQ", Filename: "video.mp4"})
+
+	expected := `{"type":"extracting","time":1500000000.25,"video_id":"dQw4w9WgXcQ","url":"https://youtu.be/dQw4w9WgXcQ"}
+{"type":"progress","time":1500000000.25,"video_id":"dQw4w9WgXcQ","filename":"video.mp4","progress":{"downloaded_bytes":500,"total_bytes":1000,"speed":100,"eta":5}}
+{"type":"progress","time":1500000000.25,"video_id":"dQw4w9WgXcQ","filename":"video.mp4","progress":{"downloaded_bytes":0,"speed":0}}
+{"type":"finished","time":1500000000.25,"video_id":"dQw4w9WgXcQ","filename":"video.mp4"}
+`
+	if buf.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, buf.String())
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Name of the feed written in the scanned directory
+const feedName = "feed.xml"
+
+// Mime types of enclosures by extension
+var feedMimeTypes = map[string]string{
+	"m4a":  "audio/mp4",
+	"mp3":  "audio/mpeg",
+	"ogg":  "audio/ogg",
+	"opus": "audio/ogg",
+	"mp4":  "video/mp4",
+	"webm": "video/webm",
+	"mkv":  "video/x-matroska",
+}
+
+// Images usable as episode artwork, in order of preference
+var feedImageExts = []string{"jpg", "png", "webp"}
+
+// RSS 2.0 feed with iTunes podcast tags
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Itunes  string     `xml:"xmlns:itunes,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string       `xml:"title"`
+	Link          string       `xml:"link"`
+	Description   string       `xml:"description"`
+	Generator     string       `xml:"generator"`
+	LastBuildDate string       `xml:"lastBuildDate,omitempty"`
+	Author        string       `xml:"itunes:author,omitempty"`
+	Image         *itunesImage `xml:"itunes:image"`
+	Items         []rssItem    `xml:"item"`
+}
+
+type itunesImage struct {
+	Href string `xml:"href,attr"`
+}
+
+type rssItem struct {
+	Title       string       `xml:"title"`
+	Link        string       `xml:"link"`
+	Description string       `xml:"description,omitempty"`
+	Guid        rssGuid      `xml:"guid"`
+	PubDate     string       `xml:"pubDate,omitempty"`
+	Enclosure   rssEnclosure `xml:"enclosure"`
+	Duration    string       `xml:"itunes:duration,omitempty"`
+	Author      string       `xml:"itunes:author,omitempty"`
+	Image       *itunesImage `xml:"itunes:image"`
+
+	published time.Time
+}
+
+type rssGuid struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Id          string `xml:",chardata"`
+}
+
+type rssEnclosure struct {
+	Url    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+// Url of a file of the directory, each path element is escaped
+func feedFileUrl(baseUrl string, rel string) string {
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return strings.TrimSuffix(baseUrl, "/") + "/" + strings.Join(parts, "/")
+}
+
+// Find the media file described by an info JSON
+// The recorded filename is used when present, other media files sharing the name otherwise
+func feedMediaFile(infoFilename string, info videoInfo) (string, os.FileInfo) {
+
+	dir := filepath.Dir(infoFilename)
+	if info.Filename != "" {
+		filename := filepath.Join(dir, info.Filename)
+		if stat, err := os.Stat(filename); err == nil {
+			return filename, stat
+		}
+	}
+
+	var exts []string
+	for ext := range feedMimeTypes {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+
+	stem := strings.TrimSuffix(infoFilename, ".info.json")
+	for _, ext := range exts {
+		if stat, err := os.Stat(stem + "." + ext); err == nil {
+			return stem + "." + ext, stat
+		}
+	}
+	return "", nil
+}
+
+// Build the feed item of a downloaded video from its info JSON
+// Return nil when the media file is missing
+func feedItem(dir string, infoFilename string, baseUrl string) (*rssItem, error) {
+
+	data, err := ioutil.ReadFile(infoFilename)
+	if err != nil {
+		return nil, err
+	}
+	var info videoInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("%s: %v", infoFilename, err)
+	}
+	if info.Video == nil {
+		return nil, fmt.Errorf("%s: no video information", infoFilename)
+	}
+
+	filename, stat := feedMediaFile(infoFilename, info)
+	if filename == "" {
+		return nil, nil
+	}
+	rel, err := filepath.Rel(dir, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	item := &rssItem{
+		Title:       info.Title,
+		Link:        info.WebpageUrl(),
+		Description: info.Description,
+		Guid:        rssGuid{Id: info.VideoId},
+		Enclosure: rssEnclosure{
+			Url:    feedFileUrl(baseUrl, rel),
+			Length: stat.Size(),
+			Type:   feedMimeTypes[strings.TrimPrefix(filepath.Ext(filename), ".")],
+		},
+		Duration: info.Duration,
+		Author:   info.Author,
+	}
+	if item.Enclosure.Type == "" {
+		item.Enclosure.Type = "application/octet-stream"
+	}
+
+	// Publication date is the upload date, the download date otherwise
+	item.published = stat.ModTime()
+	if date, err := time.Parse("20060102", info.UploadDate); err == nil {
+		item.published = date
+	}
+	item.PubDate = item.published.Format(time.RFC1123Z)
+
+	// Thumbnail written next to the file, the remote one otherwise
+	stem := strings.TrimSuffix(filename, filepath.Ext(filename))
+	for _, ext := range feedImageExts {
+		if _, err := os.Stat(stem + "." + ext); err == nil {
+			rel, _ := filepath.Rel(dir, stem+"."+ext)
+			item.Image = &itunesImage{Href: feedFileUrl(baseUrl, rel)}
+			break
+		}
+	}
+	if item.Image == nil && len(info.Thumbnails) > 0 {
+		item.Image = &itunesImage{Href: info.Thumbnails[0].Url}
+	}
+
+	return item, nil
+}
+
+// Build the feed of the videos downloaded in a directory with their info JSON
+// Items are sorted from the most recent one, unreadable info JSON are reported to out and skipped
+func BuildFeed(dir string, baseUrl string, out io.Writer) (*rssFeed, error) {
+
+	var items []rssItem
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		// Videos removed from a synced playlist are not part of the feed
+		if info.IsDir() && path != dir && info.Name() == syncRemovedDir {
+			return filepath.SkipDir
+		}
+		if info.IsDir() || !strings.HasSuffix(info.Name(), ".info.json") {
+			return nil
+		}
+
+		item, err := feedItem(dir, path, baseUrl)
+		if err != nil {
+			fmt.Fprintln(out, "Skipping", path+":", err)
+			return nil
+		}
+		if item != nil {
+			items = append(items, *item)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		if !items[i].published.Equal(items[j].published) {
+			return items[i].published.After(items[j].published)
+		}
+		return items[i].Title < items[j].Title
+	})
+
+	// Channel is named after the author shared by all videos, the directory otherwise
+	title := filepath.Base(filepath.Clean(dir))
+	var author string
+	for i, item := range items {
+		if i == 0 {
+			author = item.Author
+		} else if item.Author != author {
+			author = ""
+			break
+		}
+	}
+	if author != "" {
+		title = author
+	}
+
+	channel := rssChannel{
+		Title:       title,
+		Link:        baseUrl,
+		Description: fmt.Sprintf("Videos of %s downloaded by gotubedl", title),
+		Generator:   "gotubedl",
+		Author:      author,
+		Items:       items,
+	}
+	if len(items) > 0 {
+		channel.LastBuildDate = items[0].PubDate
+		channel.Image = items[0].Image
+	}
+
+	return &rssFeed{Version: "2.0", Itunes: "http://www.itunes.com/dtds/podcast-1.0.dtd", Channel: channel}, nil
+}
+
+// Write the feed of a directory in it
+// Return the number of items
+func WriteFeed(dir string, baseUrl string, out io.Writer) (int, error) {
+
+	feed, err := BuildFeed(dir, baseUrl, out)
+	if err != nil {
+		return 0, err
+	}
+
+	err = writeFileAtomic(filepath.Join(dir, feedName), func(f *os.File) error {
+		if _, err := io.WriteString(f, xml.Header); err != nil {
+			return err
+		}
+		encoder := xml.NewEncoder(f)
+		encoder.Indent("", "  ")
+		if err := encoder.Encode(feed); err != nil {
+			return err
+		}
+		_, err := io.WriteString(f, "\n")
+		return err
+	})
+	return len(feed.Channel.Items), err
+}
+
+// Run the feed subcommand: feed DIR
+// Return the exit code
+func runFeed(ctx context.Context, args []string, out io.Writer) int {
+
+	if len(args) != 1 || opts.BaseUrl == "" {
+		fmt.Fprintln(os.Stderr, "Usage: gotubedl [OPTIONS] feed DIR --base-url URL")
+		return 2
+	}
+
+	count, err := WriteFeed(args[0], opts.BaseUrl, out)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Fprintf(out, "Feed of %d items written to %s\n", count, filepath.Join(args[0], feedName))
+	return 0
+}
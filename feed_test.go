@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteFeed(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gotubedl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Audio with a local thumbnail, video in a sub directory, info without media
+	os.Mkdir(filepath.Join(dir, "Old Songs"), 0755)
+	os.Mkdir(filepath.Join(dir, syncRemovedDir), 0755)
+	videos := map[string]*Video{
+		"Never Gonna Give You Up.m4a": {VideoId: videoId, Title: "Never Gonna Give You Up", Author: "Rick Astley",
+			UploadDate: "20091025", Duration: "212"},
+		"Old Songs/Together Forever.mp4": {VideoId: "yPYZpwSpKmA", Title: "Together Forever", Author: "Rick Astley",
+			UploadDate: "20080101", Duration: "205", Thumbnails: []Thumbnail{{Url: "https://i.ytimg.com/vi/yPYZpwSpKmA/hqdefault.jpg"}}},
+		"Missing.mp4":                   {VideoId: "9bZkp7q19f0", Title: "Missing", Author: "Rick Astley"},
+		syncRemovedDir + "/Removed.mp4": {VideoId: "kffacxfA7G4", Title: "Removed", Author: "Rick Astley"},
+	}
+	for name, video := range videos {
+		filename := filepath.Join(dir, filepath.FromSlash(name))
+		if err := writeInfoJson(video, filename); err != nil {
+			t.Fatal(err)
+		}
+		if video.Title != "Missing" {
+			ioutil.WriteFile(filename, []byte("media"), 0644)
+		}
+	}
+	ioutil.WriteFile(filepath.Join(dir, "Never Gonna Give You Up.jpg"), []byte("jpg"), 0644)
+
+	// Broken info JSON are skipped
+	ioutil.WriteFile(filepath.Join(dir, "Broken.info.json"), []byte("{"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "Broken.mp4"), []byte("media"), 0644)
+
+	var out bytes.Buffer
+	count, err := WriteFeed(dir, "https://example.com/podcast/", &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatalf("expected 2 items, got %d", count)
+	}
+	if !strings.Contains(out.String(), "Broken.info.json") {
+		t.Errorf("expected broken info JSON to be reported, got %q", out.String())
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, feedName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`<rss version="2.0" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd">`,
+		`<title>Rick Astley</title>`,
+		`<enclosure url="https://example.com/podcast/Never%20Gonna%20Give%20You%20Up.m4a" length="5" type="audio/mp4"></enclosure>`,
+		`<enclosure url="https://example.com/podcast/Old%20Songs/Together%20Forever.mp4" length="5" type="video/mp4"></enclosure>`,
+		`<itunes:image href="https://example.com/podcast/Never%20Gonna%20Give%20You%20Up.jpg"></itunes:image>`,
+		`<itunes:image href="https://i.ytimg.com/vi/yPYZpwSpKmA/hqdefault.jpg"></itunes:image>`,
+		`<itunes:duration>212</itunes:duration>`,
+		`<pubDate>Sun, 25 Oct 2009 00:00:00 +0000</pubDate>`,
+		`<guid isPermaLink="false">` + videoId + `</guid>`,
+	} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("expected feed to contain %s, got %s", expected, data)
+		}
+	}
+
+	// Most recent first
+	var feed rssFeed
+	if err := xml.Unmarshal(data, &feed); err != nil {
+		t.Fatal(err)
+	}
+	if items := feed.Channel.Items; len(items) != 2 || items[0].Title != "Never Gonna Give You Up" {
+		t.Errorf("unexpected items %+v", items)
+	}
+}
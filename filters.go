@@ -0,0 +1,319 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Condition of a match filter: "field", "!field" or "field op value"
+// Fields without value fail comparisons unless the operator ends with ?
+type filterCondition struct {
+	Field    string
+	Negate   bool   // Field must be missing
+	Op       string // Empty checks the field is present
+	Value    string
+	Optional bool // Missing fields pass
+}
+
+var regFilterPresence = regexp.MustCompile(`^\s*(!?)\s*(\w+)\s*$`)
+var regFilterComparison = regexp.MustCompile(`^\s*(\w+)\s*(<=|>=|!=|\^=|\$=|\*=|~=|<|>|=)(\??)\s*(\S.*?)\s*$`)
+
+// Split a filter on & outside of quotes
+func splitFilter(expr string) []string {
+	var parts []string
+	var quote rune
+	start := 0
+	for i, r := range expr {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '&':
+			parts = append(parts, expr[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, expr[start:])
+}
+
+// Parse a match filter, conditions are joined by &
+func parseMatchFilter(expr string) ([]filterCondition, error) {
+
+	var conditions []filterCondition
+	for _, part := range splitFilter(expr) {
+
+		if match := regFilterPresence.FindStringSubmatch(part); match != nil {
+			conditions = append(conditions, filterCondition{Field: match[2], Negate: match[1] == "!"})
+			continue
+		}
+
+		match := regFilterComparison.FindStringSubmatch(part)
+		if match == nil {
+			return nil, fmt.Errorf("invalid filter condition %q", strings.TrimSpace(part))
+		}
+		value := match[4]
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		if match[2] == "~=" {
+			if _, err := regexp.Compile(value); err != nil {
+				return nil, err
+			}
+		}
+		conditions = append(conditions, filterCondition{
+			Field:    match[1],
+			Op:       match[2],
+			Value:    value,
+			Optional: match[3] == "?",
+		})
+	}
+
+	return conditions, nil
+}
+
+// Parse a number of a filter, sizes like 50M are allowed
+func parseFilterNumber(value string) (float64, bool) {
+	if n, err := strconv.ParseFloat(value, 64); err == nil {
+		return n, true
+	}
+	if n, err := parseSize(value); err == nil {
+		return n, true
+	}
+	return 0, false
+}
+
+// Check the condition against the fields of a video
+func (c filterCondition) match(fields map[string]string) bool {
+
+	field := fields[c.Field]
+	if c.Op == "" {
+		return (field != "") != c.Negate
+	}
+	if field == "" {
+		return c.Optional
+	}
+
+	// Numbers are compared as numbers, everything else as strings
+	if a, err := strconv.ParseFloat(field, 64); err == nil {
+		if b, ok := parseFilterNumber(c.Value); ok {
+			switch c.Op {
+			case "<":
+				return a < b
+			case "<=":
+				return a <= b
+			case ">":
+				return a > b
+			case ">=":
+				return a >= b
+			case "=":
+				return a == b
+			case "!=":
+				return a != b
+			}
+		}
+	}
+
+	switch c.Op {
+	case "<":
+		return field < c.Value
+	case "<=":
+		return field <= c.Value
+	case ">":
+		return field > c.Value
+	case ">=":
+		return field >= c.Value
+	case "=":
+		return field == c.Value
+	case "!=":
+		return field != c.Value
+	case "^=":
+		return strings.HasPrefix(field, c.Value)
+	case "$=":
+		return strings.HasSuffix(field, c.Value)
+	case "*=":
+		return strings.Contains(field, c.Value)
+	case "~=":
+		return regexp.MustCompile(c.Value).MatchString(field)
+	}
+	return false
+}
+
+// Fields usable in match filters, empty when unknown
+func filterFields(video *Video, format Format) map[string]string {
+	fields := templateFields(video, format)
+	fields["description"] = video.Description
+	fields["upload_date"] = video.UploadDate
+	fields["filesize"] = ""
+	if format.Filesize > 0 {
+		fields["filesize"] = strconv.FormatUint(format.Filesize, 10)
+	}
+	for _, name := range []string{"view_count", "width", "height", "fps", "format_id"} {
+		if fields[name] == "0" {
+			fields[name] = ""
+		}
+	}
+	return fields
+}
+
+var regRelativeDate = regexp.MustCompile(`^(now|today)(?:([+-])(\d+)(day|week|month|year)s?)?$`)
+
+// Parse a date as YYYYMMDD, relative dates like today-2weeks are allowed
+func parseFilterDate(value string, now time.Time) (string, error) {
+
+	if t, err := time.Parse("20060102", value); err == nil {
+		return t.Format("20060102"), nil
+	}
+
+	match := regRelativeDate.FindStringSubmatch(value)
+	if match == nil {
+		return "", fmt.Errorf("invalid date %s, expected YYYYMMDD or today-N(day|week|month|year)", value)
+	}
+
+	if match[2] != "" {
+		n, _ := strconv.Atoi(match[3])
+		if match[2] == "-" {
+			n = -n
+		}
+		switch match[4] {
+		case "day":
+			now = now.AddDate(0, 0, n)
+		case "week":
+			now = now.AddDate(0, 0, 7*n)
+		case "month":
+			now = now.AddDate(0, n, 0)
+		case "year":
+			now = now.AddDate(n, 0, 0)
+		}
+	}
+
+	return now.Format("20060102"), nil
+}
+
+// Filters applied to videos before downloading them
+// Unknown sizes, dates and views pass
+type VideoFilters struct {
+	MinFilesize  float64
+	MaxFilesize  float64
+	MatchTitle   *regexp.Regexp
+	RejectTitle  *regexp.Regexp
+	DateAfter    string // YYYYMMDD, inclusive
+	DateBefore   string
+	MinViews     int
+	MatchFilters []string
+	conditions   [][]filterCondition
+}
+
+// Add a match filter, a video passes when any of them matches
+func (f *VideoFilters) AddMatchFilter(expr string) error {
+	conditions, err := parseMatchFilter(expr)
+	if err != nil {
+		return err
+	}
+	f.MatchFilters = append(f.MatchFilters, expr)
+	f.conditions = append(f.conditions, conditions)
+	return nil
+}
+
+// Reason to skip the video, empty when it must be downloaded
+func (f *VideoFilters) Check(video *Video, format Format) string {
+
+	size := float64(format.Filesize)
+	if size > 0 && f.MinFilesize > 0 && size < f.MinFilesize {
+		return fmt.Sprintf("file size %d is smaller than min-filesize %.0f", format.Filesize, f.MinFilesize)
+	}
+	if size > 0 && f.MaxFilesize > 0 && size > f.MaxFilesize {
+		return fmt.Sprintf("file size %d is larger than max-filesize %.0f", format.Filesize, f.MaxFilesize)
+	}
+
+	if f.MatchTitle != nil && !f.MatchTitle.MatchString(video.Title) {
+		return fmt.Sprintf("title %q does not match %q", video.Title, f.MatchTitle)
+	}
+	if f.RejectTitle != nil && f.RejectTitle.MatchString(video.Title) {
+		return fmt.Sprintf("title %q matches reject-title %q", video.Title, f.RejectTitle)
+	}
+
+	if date := video.UploadDate; date != "" {
+		if f.DateAfter != "" && date < f.DateAfter {
+			return fmt.Sprintf("upload date %s is before %s", date, f.DateAfter)
+		}
+		if f.DateBefore != "" && date > f.DateBefore {
+			return fmt.Sprintf("upload date %s is after %s", date, f.DateBefore)
+		}
+	}
+
+	if f.MinViews > 0 && video.ViewCount > 0 && video.ViewCount < f.MinViews {
+		return fmt.Sprintf("view count %d is lower than %d", video.ViewCount, f.MinViews)
+	}
+
+	if len(f.conditions) == 0 {
+		return ""
+	}
+	fields := filterFields(video, format)
+Filters:
+	for _, conditions := range f.conditions {
+		for _, condition := range conditions {
+			if !condition.match(fields) {
+				continue Filters
+			}
+		}
+		return ""
+	}
+	return fmt.Sprintf("does not pass filter %q", strings.Join(f.MatchFilters, `" or "`))
+}
+
+// Filters of program options, nil when there are none
+var videoFilters *VideoFilters
+
+// Parse filters of program options, titles are matched case insensitively
+func setupFilters() error {
+
+	f := &VideoFilters{MinViews: opts.MinViews}
+	var err error
+	if opts.MinFilesize != "" {
+		if f.MinFilesize, err = parseSize(opts.MinFilesize); err != nil {
+			return err
+		}
+	}
+	if opts.MaxFilesize != "" {
+		if f.MaxFilesize, err = parseSize(opts.MaxFilesize); err != nil {
+			return err
+		}
+	}
+	if opts.MatchTitle != "" {
+		if f.MatchTitle, err = regexp.Compile("(?i)" + opts.MatchTitle); err != nil {
+			return err
+		}
+	}
+	if opts.RejectTitle != "" {
+		if f.RejectTitle, err = regexp.Compile("(?i)" + opts.RejectTitle); err != nil {
+			return err
+		}
+	}
+	if opts.DateAfter != "" {
+		if f.DateAfter, err = parseFilterDate(opts.DateAfter, time.Now()); err != nil {
+			return err
+		}
+	}
+	if opts.DateBefore != "" {
+		if f.DateBefore, err = parseFilterDate(opts.DateBefore, time.Now()); err != nil {
+			return err
+		}
+	}
+	for _, expr := range opts.MatchFilter {
+		if err := f.AddMatchFilter(expr); err != nil {
+			return err
+		}
+	}
+
+	if f.MinFilesize > 0 || f.MaxFilesize > 0 || f.MatchTitle != nil || f.RejectTitle != nil ||
+		f.DateAfter != "" || f.DateBefore != "" || f.MinViews > 0 || len(f.conditions) > 0 {
+		videoFilters = f
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestMatchFilter(t *testing.T) {
+
+	video := &Video{VideoId: videoId, Title: "Live at Wembley", Duration: "540", ViewCount: 1500, UploadDate: "20200301"}
+	format := Format{FormatId: 22, Ext: "mp4", Height: 720, Filesize: 30 << 20}
+	fields := filterFields(video, format)
+
+	tests := map[string]bool{
+		"duration < 600":                    true,
+		"duration > 600":                    false,
+		"filesize <= 30M & height >= 720":   true,
+		"filesize < 10M":                    false,
+		"title *= Wembley & ext = mp4":      true,
+		"title ^= 'Live at' & title $= ley": true,
+		`title ~= "^live"`:                  false,
+		"title ~= (?i)^live":                true,
+		"upload_date >= 20200101":           true,
+		"fps":                               false,
+		"!fps":                              true,
+		"fps > 30":                          false,
+		"fps >? 30":                         true,
+		"view_count != 1500":                false,
+		"title = 'a & b' & duration < 600":  false,
+	}
+	for expr, expected := range tests {
+		conditions, err := parseMatchFilter(expr)
+		if err != nil {
+			t.Errorf("%s: %v", expr, err)
+			continue
+		}
+		result := true
+		for _, condition := range conditions {
+			result = result && condition.match(fields)
+		}
+		if result != expected {
+			t.Errorf("%s: expected %v", expr, expected)
+		}
+	}
+
+	for _, expr := range []string{"duration <", "title ~= (", "a b"} {
+		if _, err := parseMatchFilter(expr); err == nil {
+			t.Errorf("%s: expected error", expr)
+		}
+	}
+}
+
+func TestParseFilterDate(t *testing.T) {
+
+	now := time.Date(2020, 3, 31, 12, 0, 0, 0, time.UTC)
+	tests := map[string]string{
+		"20190102":     "20190102",
+		"today":        "20200331",
+		"now-1day":     "20200330",
+		"today-2weeks": "20200317",
+		"today-1month": "20200302",
+		"today+1year":  "20210331",
+	}
+	for value, expected := range tests {
+		if date, err := parseFilterDate(value, now); err != nil || date != expected {
+			t.Errorf("%s: expected %s, got %s %v", value, expected, date, err)
+		}
+	}
+
+	if _, err := parseFilterDate("yesterday", now); err == nil {
+		t.Errorf("expected error for invalid date")
+	}
+}
+
+func TestVideoFilters(t *testing.T) {
+
+	video := &Video{VideoId: videoId, Title: "Live at Wembley", Duration: "540", ViewCount: 1500, UploadDate: "20200301"}
+	format := Format{FormatId: 22, Ext: "mp4", Filesize: 30 << 20}
+
+	tests := []struct {
+		filters VideoFilters
+		skipped bool
+	}{
+		{VideoFilters{}, false},
+		{VideoFilters{MaxFilesize: 10 << 20}, true},
+		{VideoFilters{MinFilesize: 10 << 20}, false},
+		{VideoFilters{MatchTitle: regexp.MustCompile("(?i)wembley")}, false},
+		{VideoFilters{RejectTitle: regexp.MustCompile("(?i)live")}, true},
+		{VideoFilters{DateAfter: "20200302"}, true},
+		{VideoFilters{DateBefore: "20200301"}, false},
+		{VideoFilters{MinViews: 2000}, true},
+	}
+	for i, test := range tests {
+		if reason := test.filters.Check(video, format); (reason != "") != test.skipped {
+			t.Errorf("%d: expected skipped %v, got %q", i, test.skipped, reason)
+		}
+	}
+
+	// Any match filter may match
+	var filters VideoFilters
+	filters.AddMatchFilter("duration > 600")
+	if filters.Check(video, format) == "" {
+		t.Errorf("expected video to be skipped")
+	}
+	filters.AddMatchFilter("title *= Live")
+	if reason := filters.Check(video, format); reason != "" {
+		t.Errorf("expected video to pass, got %q", reason)
+	}
+}
+
+func TestVideoFiltersPlayerResponse(t *testing.T) {
+
+	videoInfo := url.Values{"player_response": {`{"videoDetails":{"viewCount":"1500"}}`}}
+	video := &Video{VideoId: videoId}
+	if err := ParsePlayerResponse(videoInfo, video); err != nil {
+		t.Fatal(err)
+	}
+
+	format := Format{FormatId: 22, Ext: "mp4"}
+	filters := VideoFilters{MinViews: 1000}
+	if reason := filters.Check(video, format); reason != "" {
+		t.Errorf("expected video to pass, got %q", reason)
+	}
+	filters.MinViews = 2000
+	if filters.Check(video, format) == "" {
+		t.Errorf("expected video with %d views to be skipped", video.ViewCount)
+	}
+	filters = VideoFilters{}
+	filters.AddMatchFilter("view_count >= 1500")
+	if reason := filters.Check(video, format); reason != "" {
+		t.Errorf("expected video to pass, got %q", reason)
+	}
+}
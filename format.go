@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/dustin/go-humanize"
 	"github.com/ryanuber/columnize"
+	"io"
 	"sort"
 	"strconv"
 	"strings"
@@ -131,7 +132,7 @@ var BaseFormats = Formats{
 }
 
 // Print formats
-func PrintFormats(formats Formats) {
+func PrintFormats(formats Formats, out io.Writer) {
 
 	// Headers
 	headers := []string{
@@ -185,7 +186,7 @@ func PrintFormats(formats Formats) {
 	// Output in console or JSON
 	fLines := columnize.SimpleFormat(lines)
 	if !opts.Json {
-		fmt.Println(fLines)
+		fmt.Fprintln(out, fLines)
 	} else if opts.Json {
 
 		// flatten format map
@@ -202,6 +203,6 @@ func PrintFormats(formats Formats) {
 			jsonOutput, _ = json.Marshal(formats)
 		}
 
-		fmt.Println(string(jsonOutput))
+		fmt.Fprintln(out, string(jsonOutput))
 	}
 }
@@ -1,14 +1,17 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/cavaliercoder/grab"
 	"github.com/jessevdk/go-flags"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -126,30 +129,50 @@ func getFormatSpecs(videoInfo url.Values, video *Video) {
 
 		// Set data
 		video.Formats[formatId] = newFormat
-
-		// Generals
-		video.ViewCount = extractInt("view_count")
 	}
 }
 
 // Download a page from internet regardless of its content
+// Transient errors are retried
 // Return a string representing the body
-func downloadPage(url string) (string, error) {
+func downloadPage(ctx context.Context, url string) (page string, err error) {
+	err = retry(ctx, nil, func() error {
+		page, err = fetchPage(ctx, url)
+		return err
+	})
+	return page, err
+}
+
+// Download a page once
+func fetchPage(ctx context.Context, url string) (string, error) {
 
 	// Download url content
-	res, err := http.Get(url)
+	req, err := newRequest(url)
+	if err != nil {
+		return "", err
+	}
+	return fetchRequest(ctx, req)
+}
+
+// Send a request once and return the response body
+func fetchRequest(ctx context.Context, req *http.Request) (string, error) {
+
+	res, err := httpClient.Do(req.WithContext(ctx))
 	if err != nil {
 		return "", err
 	}
+	defer res.Body.Close()
 
 	// Gives up in status != 200
 	if res.StatusCode != http.StatusOK {
-		return "", fmt.Errorf(res.Status)
+		return "", &httpError{StatusCode: res.StatusCode, Status: res.Status}
 	}
 
-	// Get data body
-	body := res.Body
-	defer body.Close()
+	// Get data body, limited like other downloads
+	var body io.Reader = res.Body
+	if rateLimiter != nil {
+		body = rateLimiter.Reader(ctx, body)
+	}
 
 	// Un-marshall body data
 	raw, err := ioutil.ReadAll(body)
@@ -160,30 +183,89 @@ func downloadPage(url string) (string, error) {
 	return string(raw), nil
 }
 
-// Download video to file
-func downloadVideo(url string, dest string) {
+// Download video to file, retrying transient errors
+// Expired urls are replaced with the one given by refresh, download resumes where it stopped
+// Refreshes aren't counted as retries but stop after a few in a row
+// Data is written to a .part file renamed once complete, kept on errors to resume later
+func downloadVideo(ctx context.Context, url string, dest string, out io.Writer, refresh func() (string, error), progress ProgressReporter) error {
+
+	partFilename := dest + ".part"
+	expired := urlExpired(url)
+	refreshes := 0
+	err := retry(ctx, out, func() error {
+
+		if expired && refresh != nil {
+			fmt.Fprintln(out, "Media url expired, extracting video again")
+			newUrl, err := refresh()
+			if err != nil {
+				return err
+			}
+			url = newUrl
+			refreshes++
+		}
+
+		err := fetchVideo(ctx, url, partFilename, out, progress)
+		if expired = isExpired(err); expired && refresh != nil && refreshes < maxRefreshes {
+			// Retried right away with a new url
+			return errRetryNow
+		}
+		return err
+	})
+	progress.Finish(err)
+
+	if err != nil {
+		if ctx.Err() != nil {
+			fmt.Fprintln(out, "Download interrupted, partial file kept in", partFilename)
+		}
+		return err
+	}
+	if err := os.Rename(partFilename, dest); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Download saved to ./%v \n", dest)
+	return nil
+}
+
+// Download video to file once, progress is sent to the reporter
+func fetchVideo(ctx context.Context, url string, dest string, out io.Writer, progress ProgressReporter) error {
 
 	// create client
 	client := grab.NewClient()
-	req, _ := grab.NewRequest(dest, url)
+	client.HTTPClient = httpClient
+	if userAgent := httpHeaders.Get("User-Agent"); userAgent != "" {
+		client.UserAgent = userAgent
+	}
+	req, err := grab.NewRequest(dest, url)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	addHeaders(req.HTTPRequest)
+	if rateLimiter != nil {
+		req.RateLimiter = rateLimiter
+	}
 
 	// start download
-	fmt.Printf("Downloading %v...\n", req.URL())
+	fmt.Fprintf(out, "Downloading %v...\n", req.URL())
 	resp := client.Do(req)
-	fmt.Printf("  %v\n", resp.HTTPResponse.Status)
+	if resp.HTTPResponse != nil {
+		fmt.Fprintf(out, "  %v\n", resp.HTTPResponse.Status)
+	}
 
-	// start UI loop
-	t := time.NewTicker(500 * time.Millisecond)
+	// report progress until the download ends
+	t := time.NewTicker(200 * time.Millisecond)
 	defer t.Stop()
 
 Loop:
 	for {
 		select {
 		case <-t.C:
-			fmt.Printf("  transferred %v / %v bytes (%.2f%%)\n",
-				resp.BytesComplete(),
-				resp.Size,
-				100*resp.Progress())
+			progress.Update(Progress{
+				Filename:   dest,
+				Downloaded: resp.BytesComplete(),
+				Total:      resp.Size,
+				Speed:      resp.BytesPerSecond(),
+			})
 
 		case <-resp.Done:
 			// download is complete
@@ -191,74 +273,275 @@ Loop:
 		}
 	}
 
-	// check for errors
+	// check for errors, bad status are reported as such
 	if err := resp.Err(); err != nil {
-		fmt.Fprintf(os.Stderr, "Download failed: %v\n", err)
-		os.Exit(1)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if res := resp.HTTPResponse; res != nil && res.StatusCode >= 400 {
+			return &httpError{StatusCode: res.StatusCode, Status: res.Status}
+		}
+		return fmt.Errorf("download failed: %v", err)
 	}
 
-	fmt.Printf("Download saved to ./%v \n", resp.Filename)
+	return nil
 }
 
-// Main function that download a youtube video
-func download(videoUrl string) (filename string, err error) {
-
-	fmt.Println("Download video :", videoUrl)
-
-	videoId := ExtractVideoId(videoUrl)
+// Get information and formats of a video
+func extractVideo(ctx context.Context, videoId string, out io.Writer) (*Video, error) {
 
 	// Get video info
-	videoInfo, err := GetVideoInfo(videoId)
+	videoInfo, err := GetVideoInfo(ctx, videoId)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	videoResult := Video{
-		VideoId:  videoId,
-		Title:    videoInfo.Get("title"),
-		Duration: videoInfo.Get("length_seconds"),
-		Author:   videoInfo.Get("author"),
-		Formats:  Formats{},
+	videoResult := &Video{
+		VideoId:    videoId,
+		Title:      videoInfo.Get("title"),
+		Duration:   videoInfo.Get("length_seconds"),
+		Author:     videoInfo.Get("author"),
+		Formats:    Formats{},
+		Thumbnails: GetThumbnails(videoId),
 	}
 
 	// Get formats
-	getFormatSpecs(videoInfo, &videoResult)
+	getFormatSpecs(videoInfo, videoResult)
+
+	// Get description, upload date and subtitles
+	if err := ParsePlayerResponse(videoInfo, videoResult); err != nil && opts.Verbose {
+		fmt.Fprintln(out, "Unable to parse player response:", err)
+	}
+
+	// Get chapters, the player ones are only fetched when needed
+	duration := float64(itoa(videoResult.Duration))
+	videoResult.Chapters = ParseDescriptionChapters(videoResult.Description, duration)
+	if opts.EmbedChapters || opts.SplitChapters {
+		chapters, err := GetPlayerChapters(ctx, videoId, duration)
+		if err != nil && opts.Verbose {
+			fmt.Fprintln(out, "Unable to get player chapters:", err)
+		}
+		if len(chapters) > 0 {
+			videoResult.Chapters = chapters
+		}
+	}
 
 	// Get DASH formats
 	dashmpd := videoInfo.Get("dashmpd")
 	if dashmpd != "" {
-		err := ParseMPDManifest(dashmpd, &videoResult)
+		err := ParseMPDManifest(ctx, dashmpd, videoResult)
 		if err != nil {
-			fmt.Println("Unable to download MPD Manifest")
+			fmt.Fprintln(out, "Unable to download MPD Manifest")
 		}
 	} else {
-		fmt.Println("Skip download MPD Manifest")
+		fmt.Fprintln(out, "Skip download MPD Manifest")
+	}
+
+	return videoResult, nil
+}
+
+// Main function that download a youtube video
+// Videos of a playlist are given their position in it
+// Relative output templates are relative to dir, the working directory when empty
+func download(ctx context.Context, videoUrl string, playlist *PlaylistItem, dir string, out io.Writer) (filename string, err error) {
+
+	// Only requested fields are printed, one line per video
+	result := out
+	if printing() {
+		out = ioutil.Discard
+	}
+
+	fmt.Fprintln(out, "Download video :", videoUrl)
+
+	videoId := ExtractVideoId(videoUrl)
+	defer func() {
+		if err != nil {
+			emitEvent(Event{Type: "error", VideoId: videoId, Url: videoUrl, Error: err.Error()})
+		}
+	}()
+	if videoId == "" {
+		return "", fmt.Errorf("no video id found in %s", videoUrl)
 	}
 
+	// Skip videos already downloaded
+	var archive *Archive
+	if opts.DownloadArchive != "" {
+		archive = NewArchive(opts.DownloadArchive)
+		found, err := archive.Contains(videoId)
+		if err != nil {
+			return "", err
+		}
+		if found {
+			fmt.Fprintln(out, videoId, "has already been recorded in archive")
+			emitEvent(Event{Type: "finished", VideoId: videoId, Url: videoUrl, Skipped: true, Reason: "already recorded in archive"})
+			return "", nil
+		}
+	}
+
+	emitEvent(Event{Type: "extracting", VideoId: videoId, Url: videoUrl, Playlist: playlist})
+	videoResult, err := extractVideo(ctx, videoId, out)
+	if err != nil {
+		return "", err
+	}
+	videoResult.PlaylistItem = playlist
+
 	if opts.FormatList {
-		PrintFormats(videoResult.Formats)
+		PrintFormats(videoResult.Formats, out)
 		return
 	}
 
-	// Build filename
+	if opts.ListSubs {
+		PrintSubtitles(videoResult.Subtitles, out)
+		return
+	}
+
+	// Select format, best audio is picked when extracting audio
 	selectedFormat := strconv.Itoa(opts.Format)
 	format := videoResult.Formats[selectedFormat]
-	filename = videoResult.Title + "." + format.Ext
+	if opts.ExtractAudio && opts.Format == 0 {
+		format, err = SelectAudioFormat(videoResult.Formats, opts.AudioFormat)
+		if err != nil {
+			return "", err
+		}
+	}
 
-	downloadVideo(format.Url, filename)
+	// Build filename
+	output := opts.Output
+	if dir != "" && !filepath.IsAbs(output) {
+		output = filepath.Join(dir, output)
+	}
+	filename = BuildFilename(output, videoResult, format)
+	emitEvent(Event{Type: "format", VideoId: videoId, FormatId: format.FormatId, Ext: format.Ext, Filename: filename})
+
+	// Skip videos rejected by filters
+	if videoFilters != nil {
+		if reason := videoFilters.Check(videoResult, format); reason != "" {
+			fmt.Fprintf(out, "%s skipped: %s\n", videoId, reason)
+			emitEvent(Event{Type: "finished", VideoId: videoId, Url: videoUrl, Skipped: true, Reason: reason})
+			return "", nil
+		}
+	}
+
+	// Stop before downloading anything
+	if opts.Simulate || printing() {
+		if opts.GetUrl {
+			fmt.Fprintln(result, format.Url)
+		}
+		if opts.GetFilename {
+			fmt.Fprintln(result, filename)
+		}
+		for _, tmpl := range opts.Print {
+			fmt.Fprintln(result, PrintTemplate(tmpl, videoResult, format, filename))
+		}
+		return filename, nil
+	}
+
+	// Expired urls are refreshed by extracting the video again
+	refresh := func() (string, error) {
+		video, err := extractVideo(ctx, videoId, ioutil.Discard)
+		if err != nil {
+			return "", err
+		}
+		refreshed, ok := video.Formats[strconv.Itoa(format.FormatId)]
+		if !ok || refreshed.Url == "" {
+			return "", fmt.Errorf("format %d no longer available", format.FormatId)
+		}
+		return refreshed.Url, nil
+	}
+
+	if err := downloadVideo(ctx, format.Url, filename, out, refresh, newProgressReporter(videoId)); err != nil {
+		return filename, err
+	}
+
+	// Thumbnails, subtitles, metadata, audio extraction
+	filename, err = postProcess(ctx, videoResult, format, filename, out)
+	if err != nil {
+		return filename, err
+	}
+
+	// Record download
+	if archive != nil {
+		if err := archive.Add(videoId); err != nil {
+			return filename, err
+		}
+	}
 
+	emitEvent(Event{Type: "finished", VideoId: videoId, Filename: filename})
 	return filename, nil
 }
 
+// Check if fields of videos are printed instead of downloading them
+func printing() bool {
+	return opts.GetUrl || opts.GetFilename || len(opts.Print) > 0
+}
+
 // Program options
 type Options struct {
-	FormatList   bool `short:"F" long:"list-formats" description:"List all available formats of requested videos"`
-	Format       int  `short:"f" long:"format" description:"Select video by format" require:"true"`
-	Json         bool `long:"json" description:"Output only json, disable other console print"`
-	PrettyJson   bool `long:"pretty-json" description:"Prettify JSON output"`
-	Secure       bool `short:"s" long:"secure" description:"Force HTTPS"`
-	IgnoreErrors bool `short:"i" long:"ignore-errors" description:"Ignore errors"`
-	Verbose      bool `short:"v" long:"verbose" description:"Enable verbose mode"`
+	FormatList         bool     `short:"F" long:"list-formats" description:"List all available formats of requested videos"`
+	Format             int      `short:"f" long:"format" description:"Select video by format" require:"true"`
+	Output             string   `short:"o" long:"output" description:"Output filename template" default:"%(title)s.%(ext)s"`
+	Json               bool     `long:"json" description:"Output only json, disable other console print"`
+	PrettyJson         bool     `long:"pretty-json" description:"Prettify JSON output"`
+	Secure             bool     `short:"s" long:"secure" description:"Force HTTPS"`
+	IgnoreErrors       bool     `short:"i" long:"ignore-errors" description:"Ignore errors"`
+	Verbose            bool     `short:"v" long:"verbose" description:"Enable verbose mode"`
+	WriteThumbnail     bool     `long:"write-thumbnail" description:"Write best thumbnail image to disk"`
+	WriteAllThumbnails bool     `long:"write-all-thumbnails" description:"Write all thumbnail image formats to disk"`
+	WriteInfoJson      bool     `long:"write-info-json" description:"Write video information to a .info.json file"`
+	ConvertThumbnails  string   `long:"convert-thumbnails" description:"Convert thumbnails to another format" choice:"jpg" choice:"png"`
+	EmbedMetadata      bool     `long:"embed-metadata" description:"Write metadata to the video file"`
+	EmbedThumbnail     bool     `long:"embed-thumbnail" description:"Embed thumbnail in the video file as cover art"`
+	ExtractAudio       bool     `short:"x" long:"extract-audio" description:"Download best audio and remux it to a standalone audio file"`
+	AudioFormat        string   `long:"audio-format" description:"Audio format of extracted audio" choice:"best" choice:"m4a" choice:"opus" choice:"ogg" default:"best"`
+	ListSubs           bool     `long:"list-subs" description:"List available subtitles of requested videos"`
+	WriteSubs          bool     `long:"write-subs" description:"Write subtitles to disk"`
+	WriteAutoSubs      bool     `long:"write-auto-subs" description:"Write automatic captions to disk"`
+	SubLangs           string   `long:"sub-langs" description:"Languages of subtitles to write, comma separated regexps or all" default:"en"`
+	SubFormat          string   `long:"sub-format" description:"Format of subtitles to download" choice:"json3" choice:"srv3" choice:"vtt" default:"vtt"`
+	ConvertSubs        string   `long:"convert-subs" description:"Convert subtitles to another format" choice:"srt" choice:"vtt" choice:"ass" choice:"txt"`
+	EmbedSubs          bool     `long:"embed-subs" description:"Embed subtitles in the video file (mp4, webm and mkv only)"`
+	EmbedChapters      bool     `long:"embed-chapters" description:"Add chapter markers to the video file"`
+	SplitChapters      bool     `long:"split-chapters" description:"Split video into multiple files based on chapters"`
+	ChapterOutput      string   `long:"chapter-output" description:"Filename template of chapter files" default:"%(title)s - %(chapter_number)03d %(chapter)s.%(ext)s"`
+	DownloadArchive    string   `long:"download-archive" description:"Download only videos not listed in the archive file and record the downloaded ones"`
+	BatchFile          string   `short:"a" long:"batch-file" description:"File containing urls to download, one per line, - for stdin"`
+	ConcurrentVideos   int      `short:"N" long:"concurrent-videos" description:"Number of videos downloaded in parallel" default:"1"`
+	Retries            int      `short:"R" long:"retries" description:"Number of retries of transient errors" default:"10"`
+	Proxy              string   `long:"proxy" description:"Use the given HTTP, HTTPS or SOCKS5 proxy (e.g. socks5://127.0.0.1:1080)"`
+	SourceAddress      string   `long:"source-address" description:"Client-side IP address to bind to"`
+	ForceIPv4          bool     `short:"4" long:"force-ipv4" description:"Make all connections via IPv4"`
+	ForceIPv6          bool     `short:"6" long:"force-ipv6" description:"Make all connections via IPv6"`
+	UserAgent          string   `long:"user-agent" description:"Custom user agent"`
+	AddHeaders         []string `long:"add-header" description:"Custom HTTP header as name:value, may be repeated"`
+	SocketTimeout      int      `long:"socket-timeout" description:"Seconds to wait for a connection or a response before giving up" default:"20"`
+	Cookies            string   `long:"cookies" description:"Netscape formatted file to read cookies from"`
+	SaveCookies        bool     `long:"save-cookies" description:"Write updated cookies back to the cookies file on exit"`
+	PlaylistItems      string   `long:"playlist-items" description:"Playlist items to download, comma separated indices and ranges (e.g. 1-3,7,10-)"`
+	PlaylistStart      int      `long:"playlist-start" description:"Playlist item to start at" default:"1"`
+	PlaylistEnd        int      `long:"playlist-end" description:"Playlist item to end at, 0 for the last one" default:"0"`
+	PlaylistReverse    bool     `long:"playlist-reverse" description:"Download playlist items in reverse order"`
+	PlaylistRandom     bool     `long:"playlist-random" description:"Download playlist items in random order"`
+	SyncRemoved        string   `long:"sync-removed" description:"What sync does with videos removed from the playlist" choice:"keep" choice:"delete" choice:"move" default:"keep"`
+	WatchInterval      string   `long:"watch-interval" description:"Time between two checks of watched subscriptions" default:"1h"`
+	BaseUrl            string   `long:"base-url" description:"Url the feed directory is served at, prefixing enclosure urls"`
+	MinFilesize        string   `long:"min-filesize" description:"Skip videos smaller than this size (e.g. 50K or 44.6M)"`
+	MaxFilesize        string   `long:"max-filesize" description:"Skip videos larger than this size (e.g. 50K or 44.6M)"`
+	MatchTitle         string   `long:"match-title" description:"Download only videos with a title matching the regexp, case insensitive"`
+	RejectTitle        string   `long:"reject-title" description:"Skip videos with a title matching the regexp, case insensitive"`
+	DateAfter          string   `long:"dateafter" description:"Download only videos uploaded on or after this date (YYYYMMDD or today-N(day|week|month|year))"`
+	DateBefore         string   `long:"datebefore" description:"Download only videos uploaded on or before this date (YYYYMMDD or today-N(day|week|month|year))"`
+	MinViews           int      `long:"min-views" description:"Skip videos with fewer views"`
+	MatchFilter        []string `long:"match-filter" description:"Generic video filter, conditions joined by & (e.g. \"duration < 600 & title *= live\"), may be repeated to match any"`
+	Simulate           bool     `long:"simulate" description:"Extract videos and select formats without downloading"`
+	GetUrl             bool     `short:"g" long:"get-url" description:"Print the url of the selected format instead of downloading"`
+	GetFilename        bool     `long:"get-filename" description:"Print the output filename instead of downloading"`
+	Print              []string `short:"O" long:"print" description:"Print a field or an output template instead of downloading, may be repeated"`
+	ProgressJson       bool     `long:"progress-json" description:"Write progress events as newline delimited JSON"`
+	ProgressFd         int      `long:"progress-fd" description:"File descriptor receiving progress events" default:"2"`
+	ConfigLocation     string   `long:"config-location" description:"Location of the config file (default: ~/.config/gotubedl/config)"`
+	Profile            string   `long:"profile" description:"Section of the config file to apply on top of its common options"`
+	LimitRate          string   `short:"r" long:"limit-rate" description:"Maximum download rate shared by all downloads, in bytes per second (e.g. 50K or 4.2M)"`
+	LimitRateSchedule  string   `long:"limit-rate-schedule" description:"Rates by time of day, overriding the limit rate (e.g. 01:00-06:00=unlimited,09:00-18:00=500K)"`
 }
 
 // Global program options
@@ -266,14 +549,25 @@ var opts Options
 
 func main() {
 
+	// Config file flags come first so command line flags override them
+	configArgs, err := loadConfig(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	mergedArgs, err := mergeArgs(configArgs, os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	allArgs := append([]string{os.Args[0]}, mergedArgs...)
+
 	// Assume the rest is video urls
-	args, err := flags.ParseArgs(&opts, os.Args)
+	args, err := flags.ParseArgs(&opts, allArgs)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	// No videos to process
-	if len(args) <= 0 {
+	// No videos to process, first param is app executable
+	if len(args) <= 1 && opts.BatchFile == "" {
 		log.Fatal("No video to process")
 	}
 
@@ -282,16 +576,109 @@ func main() {
 		opts.Verbose = false
 	}
 
-	// First param is app executable
-	for _, videoUrl := range args[1:] {
+	// Empty template fallback to default one
+	if opts.Output == "" {
+		opts.Output = DefaultOutputTemplate
+	}
+	if opts.ChapterOutput == "" {
+		opts.ChapterOutput = DefaultChapterTemplate
+	}
 
-		//filename, err := download(videoUrl)
-		_, err := download(videoUrl)
+	if err := setupNetwork(); err != nil {
+		log.Fatal(err)
+	}
+	if err := setupEvents(); err != nil {
+		log.Fatal(err)
+	}
+	if err := setupFilters(); err != nil {
+		log.Fatal(err)
+	}
+	if err := setupRateLimiter(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Progress bars are only drawn on terminals
+	var stdout io.Writer = os.Stdout
+	if !opts.Json && isTerminal(os.Stdout) {
+		progressDisplay = NewProgressDisplay(os.Stdout)
+		stdout = progressDisplay
+	}
+	ctx := handleSignals()
+
+	// Subcommands
+	subcommands := map[string]func(context.Context, []string, io.Writer) int{
+		"sync":   runSync,
+		"watch":  runWatch,
+		"import": runImport,
+		"feed":   runFeed,
+	}
+	if len(args) > 1 && subcommands[args[1]] != nil {
+		code := subcommands[args[1]](ctx, args[2:], stdout)
+		if progressDisplay != nil {
+			progressDisplay.Close()
+		}
+		saveCookies()
+		os.Exit(code)
+	}
+
+	pool := newWorkerPool(ctx, opts.ConcurrentVideos, stdout)
+
+	// Playlists are queued video by video
+	add := func(videoUrl string, source string) {
+		playlistId := ExtractPlaylistId(videoUrl)
+		if playlistId == "" || ExtractVideoId(videoUrl) != "" {
+			pool.Add(videoUrl, source, nil)
+			return
+		}
+		videos, err := GetPlaylistVideos(ctx, playlistId)
 		if err != nil {
-			fmt.Println(err)
-			continue
+			pool.Fail(videoUrl, source, err)
+			return
 		}
+		for _, video := range videos {
+			pool.Add(video.WebpageUrl(), fmt.Sprintf("%s #%d", source, video.PlaylistIndex), video.PlaylistItem)
+		}
+	}
+
+	// Urls of the batch file come first
+	var batchErr error
+	if opts.BatchFile != "" {
+		batchErr = readBatchFile(opts.BatchFile, func(videoUrl string, line int) {
+			add(videoUrl, fmt.Sprintf("%s:%d", opts.BatchFile, line))
+		})
+	}
+
+	for _, videoUrl := range args[1:] {
+		add(videoUrl, videoUrl)
+	}
+
+	// Summary of failed videos
+	failures := pool.Wait()
+	if progressDisplay != nil {
+		progressDisplay.Close()
+	}
+	if len(failures) > 0 {
+		if pool.count > 1 {
+			fmt.Fprintf(os.Stderr, "%d of %d videos failed:\n", len(failures), pool.count)
+			for _, job := range failures {
+				fmt.Fprintf(os.Stderr, "  %s: %v\n", job.source, job.err)
+			}
+		}
+	}
+	if batchErr != nil {
+		fmt.Fprintln(os.Stderr, "Unable to read batch file:", batchErr)
+	}
+	if ctx.Err() != nil {
+		done := pool.count - len(failures) - pool.interrupted
+		fmt.Fprintf(os.Stderr, "Interrupted: %d of %d videos done, %d failed, %d stopped\n",
+			done, pool.count, len(failures), pool.interrupted)
+	}
 
-		// fmt.Println("Download to", filename)
+	saveCookies()
+	if ctx.Err() != nil {
+		os.Exit(130)
+	}
+	if len(failures) > 0 || batchErr != nil {
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Channel found in an export
+type importedChannel struct {
+	Url   string
+	Title string
+}
+
+var regChannelUrlId = regexp.MustCompile(`^UC[0-9A-Za-z_-]{22}$`)
+
+// Parse subscriptions.csv of Google Takeout: channel id, channel url and title
+// Header names are translated, columns are found by content
+func ParseTakeoutCSV(r io.Reader) ([]importedChannel, error) {
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var channels []importedChannel
+	for _, record := range records {
+		if len(record) < 2 {
+			continue
+		}
+		id := strings.TrimPrefix(strings.TrimSpace(record[0]), "\ufeff")
+		title := ""
+		if len(record) > 2 {
+			title = strings.TrimSpace(record[2])
+		}
+
+		switch {
+		case regChannelUrlId.MatchString(id):
+			channels = append(channels, importedChannel{Url: "https://www.youtube.com/channel/" + id, Title: title})
+		case strings.Contains(record[1], "youtube.com/"):
+			channels = append(channels, importedChannel{Url: strings.TrimSpace(record[1]), Title: title})
+		}
+	}
+
+	return channels, nil
+}
+
+// Outline of an OPML file, feeds may be nested in folders
+type opmlOutline struct {
+	Text     string        `xml:"text,attr"`
+	Title    string        `xml:"title,attr"`
+	XmlUrl   string        `xml:"xmlUrl,attr"`
+	HtmlUrl  string        `xml:"htmlUrl,attr"`
+	Outlines []opmlOutline `xml:"outline"`
+}
+
+// Url of the channel, user or playlist of a youtube feed
+func feedChannelUrl(feedUrl string) string {
+	u, err := url.Parse(feedUrl)
+	if err != nil || !strings.Contains(u.Host, "youtube.com") {
+		return ""
+	}
+	query := u.Query()
+	switch {
+	case query.Get("channel_id") != "":
+		return "https://www.youtube.com/channel/" + query.Get("channel_id")
+	case query.Get("user") != "":
+		return "https://www.youtube.com/user/" + query.Get("user")
+	case query.Get("playlist_id") != "":
+		return "https://www.youtube.com/playlist?list=" + query.Get("playlist_id")
+	}
+	return ""
+}
+
+// Parse an OPML export of youtube feeds, other feeds are ignored
+func ParseOPML(r io.Reader) ([]importedChannel, error) {
+
+	var opml struct {
+		Outlines []opmlOutline `xml:"body>outline"`
+	}
+	if err := xml.NewDecoder(r).Decode(&opml); err != nil {
+		return nil, err
+	}
+
+	var channels []importedChannel
+	var walk func(outlines []opmlOutline)
+	walk = func(outlines []opmlOutline) {
+		for _, outline := range outlines {
+			channelUrl := feedChannelUrl(outline.XmlUrl)
+			if channelUrl == "" && strings.Contains(outline.HtmlUrl, "youtube.com/") {
+				channelUrl = outline.HtmlUrl
+			}
+			if channelUrl != "" {
+				title := outline.Title
+				if title == "" {
+					title = outline.Text
+				}
+				channels = append(channels, importedChannel{Url: channelUrl, Title: title})
+			}
+			walk(outline.Outlines)
+		}
+	}
+	walk(opml.Outlines)
+
+	return channels, nil
+}
+
+// Parse an export, OPML or Takeout CSV depending on its content
+func parseSubscriptionExport(filename string) ([]importedChannel, error) {
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	data = bytes.TrimPrefix(data, []byte("\ufeff"))
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("<")) {
+		return ParseOPML(bytes.NewReader(data))
+	}
+	return ParseTakeoutCSV(bytes.NewReader(data))
+}
+
+// Key identifying a subscription, urls of a channel differ by scheme, host or trailing path
+func subscriptionKey(subscriptionUrl string) string {
+	if match := regChannelId.FindStringSubmatch(subscriptionUrl); match != nil {
+		return "channel:UC" + match[1]
+	}
+	if match := regUserName.FindStringSubmatch(subscriptionUrl); match != nil {
+		return "user:" + strings.ToLower(match[1])
+	}
+	if playlistId := ExtractPlaylistId(subscriptionUrl); playlistId != "" {
+		return "playlist:" + playlistId
+	}
+	return subscriptionUrl
+}
+
+// Add channels of exports to a subscriptions file, known ones are skipped
+// Each channel is written with its title as comment
+// Return the number of added channels
+func ImportSubscriptions(filename string, exports []string) (int, error) {
+
+	known := map[string]bool{}
+	existing, err := ioutil.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	if err == nil {
+		subscriptions, err := readSubscriptions(filename)
+		if err != nil {
+			return 0, err
+		}
+		for _, subscription := range subscriptions {
+			known[subscriptionKey(subscription.Url)] = true
+		}
+	}
+
+	var added bytes.Buffer
+	var count int
+	for _, export := range exports {
+		channels, err := parseSubscriptionExport(export)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %v", export, err)
+		}
+		for _, channel := range channels {
+			key := subscriptionKey(channel.Url)
+			if known[key] {
+				continue
+			}
+			known[key] = true
+			if channel.Title != "" {
+				fmt.Fprintf(&added, "# %s\n", strings.Replace(channel.Title, "\n", " ", -1))
+			}
+			fmt.Fprintln(&added, channel.Url)
+			count++
+		}
+	}
+
+	if count == 0 {
+		return 0, nil
+	}
+
+	err = writeFileAtomic(filename, func(f *os.File) error {
+		w := bufio.NewWriter(f)
+		w.Write(existing)
+		if len(existing) > 0 && existing[len(existing)-1] != '\n' {
+			w.WriteString("\n")
+		}
+		added.WriteTo(w)
+		return w.Flush()
+	})
+	return count, err
+}
+
+// Run the import subcommand: import SUBSCRIPTIONS EXPORT...
+// Return the exit code
+func runImport(ctx context.Context, args []string, out io.Writer) int {
+
+	if len(args) < 2 {
+		fmt.Fprintln(os.Stderr, "Usage: gotubedl import SUBSCRIPTIONS EXPORT...")
+		return 2
+	}
+
+	count, err := ImportSubscriptions(args[0], args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Fprintf(out, "Added %d subscriptions to %s\n", count, filepath.Clean(args[0]))
+	return 0
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testTakeout = "\ufeffChannel Id,Channel Url,Channel Title\n" +
+	"UCuAXFkgsw1L7xaCfnd5JJOw,http://www.youtube.com/channel/UCuAXFkgsw1L7xaCfnd5JJOw,Rick Astley\n" +
+	"UCBR8-60-B28hp2BmDPdntcQ,http://www.youtube.com/channel/UCBR8-60-B28hp2BmDPdntcQ,\"YouTube, official\"\n"
+
+const testOPML = `<?xml version="1.0" encoding="UTF-8"?>
+<opml version="1.1">
+ <body>
+  <outline text="YouTube Subscriptions" title="YouTube Subscriptions">
+   <outline text="Rick Astley" title="Rick Astley" type="rss" xmlUrl="https://www.youtube.com/feeds/videos.xml?channel_id=UCuAXFkgsw1L7xaCfnd5JJOw"/>
+   <outline text="Favorites" type="rss" xmlUrl="https://www.youtube.com/feeds/videos.xml?playlist_id=PLFgquLnL59alCl_2TQvOiD5Vgm1hCaGSI"/>
+  </outline>
+  <outline text="Blog" type="rss" xmlUrl="https://example.com/feed.xml"/>
+ </body>
+</opml>`
+
+func TestParseTakeoutCSV(t *testing.T) {
+
+	channels, err := ParseTakeoutCSV(strings.NewReader(testTakeout))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []importedChannel{
+		{Url: "https://www.youtube.com/channel/UCuAXFkgsw1L7xaCfnd5JJOw", Title: "Rick Astley"},
+		{Url: "https://www.youtube.com/channel/UCBR8-60-B28hp2BmDPdntcQ", Title: "YouTube, official"},
+	}
+	if !reflect.DeepEqual(channels, expected) {
+		t.Errorf("expected %+v, got %+v", expected, channels)
+	}
+}
+
+func TestParseOPML(t *testing.T) {
+
+	channels, err := ParseOPML(strings.NewReader(testOPML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []importedChannel{
+		{Url: "https://www.youtube.com/channel/UCuAXFkgsw1L7xaCfnd5JJOw", Title: "Rick Astley"},
+		{Url: "https://www.youtube.com/playlist?list=PLFgquLnL59alCl_2TQvOiD5Vgm1hCaGSI", Title: "Favorites"},
+	}
+	if !reflect.DeepEqual(channels, expected) {
+		t.Errorf("expected %+v, got %+v", expected, channels)
+	}
+}
+
+func TestImportSubscriptions(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gotubedl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	takeout := filepath.Join(dir, "subscriptions.csv")
+	opml := filepath.Join(dir, "subscriptions.opml")
+	filename := filepath.Join(dir, "subscriptions.txt")
+	ioutil.WriteFile(takeout, []byte(testTakeout), 0644)
+	ioutil.WriteFile(opml, []byte(testOPML), 0644)
+	ioutil.WriteFile(filename, []byte("http://youtube.com/channel/UCuAXFkgsw1L7xaCfnd5JJOw/videos music"), 0644)
+
+	// Rick Astley is already known, and listed in both exports
+	count, err := ImportSubscriptions(filename, []string{takeout, opml})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("expected 2 added subscriptions, got %d", count)
+	}
+
+	subscriptions, err := readSubscriptions(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Subscription{
+		{Url: "http://youtube.com/channel/UCuAXFkgsw1L7xaCfnd5JJOw/videos", Dir: "music"},
+		{Url: "https://www.youtube.com/channel/UCBR8-60-B28hp2BmDPdntcQ"},
+		{Url: "https://www.youtube.com/playlist?list=PLFgquLnL59alCl_2TQvOiD5Vgm1hCaGSI"},
+	}
+	if !reflect.DeepEqual(subscriptions, expected) {
+		t.Errorf("expected %+v, got %+v", expected, subscriptions)
+	}
+
+	// Importing again adds nothing
+	if count, err := ImportSubscriptions(filename, []string{takeout, opml}); err != nil || count != 0 {
+		t.Errorf("expected no added subscriptions, got %d %v", count, err)
+	}
+}
@@ -0,0 +1,832 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+)
+
+// Elements found at the first level of a segment
+var mkvTopLevel = map[uint32]bool{
+	mkvSeekHead: true, mkvInfo: true, mkvTracks: true, mkvCues: true,
+	mkvCluster: true, mkvTags: true, mkvAttachments: true, mkvChapters: true,
+}
+
+// Element kept on disk, or built in memory
+type ebmlRef struct {
+	Offset int64  // Offset of the element in file
+	Size   int64  // Size including header
+	Data   []byte // Element built in memory
+}
+
+// Reader of the element, header included
+func (ref ebmlRef) reader(r io.ReaderAt) *io.SectionReader {
+	if ref.Data != nil {
+		return io.NewSectionReader(bytes.NewReader(ref.Data), 0, int64(len(ref.Data)))
+	}
+	return io.NewSectionReader(r, ref.Offset, ref.Size)
+}
+
+// Matroska / WebM file, clusters stay on disk
+type matroskaFile struct {
+	r           io.ReaderAt
+	header      []byte     // Raw EBML header
+	segmentData int64      // Offset of segment data in file
+	Elements    []*Element // Top level elements other than clusters and cues
+	Cues        *Element
+	Clusters    []ebmlRef
+
+	// Shift of cluster timecodes on write, for files cut in parts
+	timecodeOffset int64
+}
+
+// Block of frames of one track
+type Block struct {
+	Track    uint64
+	Timecode int64 // Absolute, in timecode scale units
+	Keyframe bool
+	Frames   [][]byte
+}
+
+// Read id and size of the element at offset
+func readElementHeader(r io.ReaderAt, offset int64) (id uint32, size uint64, headerSize int64, err error) {
+
+	buf := make([]byte, 12)
+	n, err := r.ReadAt(buf, offset)
+	if n == 0 {
+		return 0, 0, 0, err
+	}
+
+	id, idLength, err := readElementId(buf[:n])
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	size, sizeLength, err := readVint(buf[idLength:n])
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	return id, size, int64(idLength + sizeLength), nil
+}
+
+// Parse a matroska file
+func openMatroska(r io.ReaderAt, fileSize int64) (*matroskaFile, error) {
+
+	// EBML header is copied as is
+	id, size, headerSize, err := readElementHeader(r, 0)
+	if err != nil {
+		return nil, err
+	}
+	if id != mkvEBML || size == ebmlUnknownSize {
+		return nil, fmt.Errorf("not a matroska file")
+	}
+
+	m := &matroskaFile{r: r}
+	m.header = make([]byte, headerSize+int64(size))
+	if _, err := r.ReadAt(m.header, 0); err != nil {
+		return nil, err
+	}
+
+	// Segment
+	offset := int64(len(m.header))
+	id, size, headerSize, err = readElementHeader(r, offset)
+	if err != nil {
+		return nil, err
+	}
+	if id != mkvSegment {
+		return nil, fmt.Errorf("no segment found")
+	}
+
+	m.segmentData = offset + headerSize
+	end := fileSize
+	if size != ebmlUnknownSize && m.segmentData+int64(size) < fileSize {
+		end = m.segmentData + int64(size)
+	}
+
+	// Top level elements
+	for offset = m.segmentData; offset < end; {
+
+		id, size, headerSize, err := readElementHeader(r, offset)
+		if err != nil {
+			return nil, err
+		}
+
+		// Live streams have clusters of unknown size
+		if size == ebmlUnknownSize {
+			size, err = m.unknownSize(offset+headerSize, end)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		ref := ebmlRef{Offset: offset, Size: headerSize + int64(size)}
+		if ref.Offset+ref.Size > end {
+			return nil, fmt.Errorf("truncated element %x", id)
+		}
+
+		switch id {
+		case mkvCluster:
+			m.Clusters = append(m.Clusters, ref)
+		case mkvSeekHead, mkvVoid, mkvCRC32:
+			// Rebuilt on write
+		default:
+			element, err := m.load(ref)
+			if err != nil {
+				return nil, err
+			}
+			if id == mkvCues {
+				m.Cues = element
+			} else {
+				m.Elements = append(m.Elements, element)
+			}
+		}
+
+		offset += ref.Size
+	}
+
+	return m, nil
+}
+
+// Find the end of an element of unknown size
+// It ends when another top level element starts
+func (m *matroskaFile) unknownSize(offset int64, end int64) (uint64, error) {
+
+	start := offset
+	for offset < end {
+		id, size, headerSize, err := readElementHeader(m.r, offset)
+		if err != nil {
+			return 0, err
+		}
+		if mkvTopLevel[id] {
+			break
+		}
+		if size == ebmlUnknownSize {
+			return 0, fmt.Errorf("nested element of unknown size")
+		}
+		offset += headerSize + int64(size)
+	}
+
+	return uint64(offset - start), nil
+}
+
+// Load an element from disk
+func (m *matroskaFile) load(ref ebmlRef) (*Element, error) {
+	raw := make([]byte, ref.Size)
+	if _, err := ref.reader(m.r).ReadAt(raw, 0); err != nil {
+		return nil, err
+	}
+	elements, err := ParseElements(raw)
+	if err != nil {
+		return nil, err
+	}
+	return elements[0], nil
+}
+
+// Top level element with the given id
+func (m *matroskaFile) Element(id uint32) *Element {
+	for _, element := range m.Elements {
+		if element.Id == id {
+			return element
+		}
+	}
+	return nil
+}
+
+// Replace a top level element, or add it
+func (m *matroskaFile) SetElement(element *Element) {
+	for i, e := range m.Elements {
+		if e.Id == element.Id {
+			m.Elements[i] = element
+			return
+		}
+	}
+	m.Elements = append(m.Elements, element)
+}
+
+// Track entries of the file
+func (m *matroskaFile) Tracks() []*Element {
+	tracks := m.Element(mkvTracks)
+	if tracks == nil {
+		return nil
+	}
+	return tracks.ChildrenById(mkvTrackEntry)
+}
+
+// Duration of a timecode unit in nanoseconds
+func (m *matroskaFile) TimecodeScale() int64 {
+	if info := m.Element(mkvInfo); info != nil {
+		return int64(info.ChildUint(mkvTimecodeScale, 1000000))
+	}
+	return 1000000
+}
+
+// Iterate over all blocks of the file
+func (m *matroskaFile) ReadBlocks(fn func(block Block) error) error {
+
+	for _, ref := range m.Clusters {
+
+		cluster, err := m.load(ref)
+		if err != nil {
+			return err
+		}
+		timecode := int64(cluster.ChildUint(mkvTimecode, 0))
+
+		for _, child := range cluster.Children {
+
+			var block Block
+			switch child.Id {
+			case mkvSimpleBlock:
+				block, err = parseBlock(child.Data, timecode)
+			case mkvBlockGroup:
+				// Block groups without reference are keyframes
+				raw := child.Child(mkvBlock)
+				if raw == nil {
+					continue
+				}
+				block, err = parseBlock(raw.Data, timecode)
+				block.Keyframe = child.Child(mkvReferenceBlock) == nil
+			default:
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			if err := fn(block); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Parse block data and split laced frames
+func parseBlock(data []byte, clusterTimecode int64) (Block, error) {
+
+	var block Block
+
+	track, n, err := readVint(data)
+	if err != nil || len(data) < n+3 {
+		return block, fmt.Errorf("invalid block")
+	}
+
+	block.Track = track
+	block.Timecode = clusterTimecode + int64(int16(binary.BigEndian.Uint16(data[n:])))
+	flags := data[n+2]
+	block.Keyframe = flags&0x80 != 0
+	payload := data[n+3:]
+
+	lacing := (flags >> 1) & 3
+	if lacing == 0 {
+		block.Frames = [][]byte{payload}
+		return block, nil
+	}
+
+	if len(payload) == 0 {
+		return block, fmt.Errorf("invalid laced block")
+	}
+	count := int(payload[0]) + 1
+	payload = payload[1:]
+	sizes := make([]int, count)
+
+	switch lacing {
+	case 1: // Xiph
+		for i := 0; i < count-1; i++ {
+			size, n, err := readXiphSize(payload)
+			if err != nil {
+				return block, err
+			}
+			sizes[i] = size
+			payload = payload[n:]
+		}
+	case 2: // Fixed
+		for i := range sizes {
+			sizes[i] = len(payload) / count
+		}
+	case 3: // EBML, sizes are stored as differences
+		for i := 0; i < count-1; i++ {
+			value, n, err := readVint(payload)
+			if err != nil {
+				return block, err
+			}
+			payload = payload[n:]
+			if i == 0 {
+				sizes[i] = int(value)
+			} else {
+				sizes[i] = sizes[i-1] + int(int64(value)-(1<<(7*uint(n)-1)-1))
+			}
+		}
+	}
+
+	// Last frame takes what remains
+	if lacing != 2 {
+		total := 0
+		for _, size := range sizes[:count-1] {
+			total += size
+		}
+		sizes[count-1] = len(payload) - total
+	}
+
+	for _, size := range sizes {
+		if size < 0 || size > len(payload) {
+			return block, fmt.Errorf("invalid lacing")
+		}
+		block.Frames = append(block.Frames, payload[:size])
+		payload = payload[size:]
+	}
+
+	return block, nil
+}
+
+// Read a xiph laced size, sum of bytes up to the first one below 255
+func readXiphSize(data []byte) (size int, n int, err error) {
+	for {
+		if n >= len(data) {
+			return 0, 0, fmt.Errorf("invalid xiph lacing")
+		}
+		b := data[n]
+		size += int(b)
+		n++
+		if b != 255 {
+			return size, n, nil
+		}
+	}
+}
+
+// Build the seek head indexing top level elements
+// Positions are always written on 8 bytes so the size doesn't depend on them
+func buildSeekHead(ids []uint32, positions []int64) *Element {
+	seekHead := NewMasterElement(mkvSeekHead)
+	for i, id := range ids {
+		position := make([]byte, 8)
+		binary.BigEndian.PutUint64(position, uint64(positions[i]))
+		seekHead.Children = append(seekHead.Children, NewMasterElement(mkvSeek,
+			NewBinaryElement(mkvSeekId, encodeElementId(id)),
+			NewBinaryElement(mkvSeekPosition, position)))
+	}
+	return seekHead
+}
+
+// Write the file, clusters are copied from the source
+// Cues are moved after the clusters and their positions updated
+func (m *matroskaFile) Write(f *os.File) error {
+
+	if _, err := f.Write(m.header); err != nil {
+		return err
+	}
+
+	// Segment size is patched once everything is written
+	segmentSizePos := int64(len(m.header)) + 4
+	if _, err := f.Write(encodeElementId(mkvSegment)); err != nil {
+		return err
+	}
+	if _, err := f.Write(encodeVintWidth(0, 8)); err != nil {
+		return err
+	}
+	dataStart := segmentSizePos + 8
+
+	// Index every element, cues come last
+	var ids []uint32
+	for _, element := range m.Elements {
+		ids = append(ids, element.Id)
+	}
+	if m.Cues != nil {
+		ids = append(ids, mkvCues)
+	}
+	positions := make([]int64, len(ids))
+	seekHead := buildSeekHead(ids, positions)
+
+	position := int64(seekHead.Size())
+	if _, err := f.Write(seekHead.Bytes()); err != nil {
+		return err
+	}
+
+	for i, element := range m.Elements {
+		positions[i] = position
+		position += int64(element.Size())
+		if _, err := f.Write(element.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	// Clusters, remember where they moved for cues
+	clusterPositions := make(map[uint64]uint64)
+	for _, ref := range m.Clusters {
+		if ref.Data == nil {
+			clusterPositions[uint64(ref.Offset-m.segmentData)] = uint64(position)
+		}
+		if m.timecodeOffset != 0 {
+			size, err := m.writeShiftedCluster(f, ref)
+			if err != nil {
+				return err
+			}
+			position += size
+			continue
+		}
+		if _, err := io.Copy(f, ref.reader(m.r)); err != nil {
+			return err
+		}
+		position += ref.Size
+	}
+
+	if m.Cues != nil {
+		for _, cuePoint := range m.Cues.ChildrenById(mkvCuePoint) {
+			for _, trackPositions := range cuePoint.ChildrenById(mkvCueTrackPositions) {
+				clusterPosition := trackPositions.Child(mkvCueClusterPosition)
+				if clusterPosition == nil {
+					continue
+				}
+				if newPosition, ok := clusterPositions[clusterPosition.Uint()]; ok {
+					clusterPosition.Data = encodeUint(newPosition)
+				}
+			}
+		}
+		positions[len(positions)-1] = position
+		position += int64(m.Cues.Size())
+		if _, err := f.Write(m.Cues.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	// Patch seek head and segment size
+	seekHead = buildSeekHead(ids, positions)
+	if _, err := f.WriteAt(seekHead.Bytes(), dataStart); err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(encodeVintWidth(uint64(position), 8), segmentSizePos); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Write a cluster with its timecode shifted, return the written size
+func (m *matroskaFile) writeShiftedCluster(f *os.File, ref ebmlRef) (int64, error) {
+
+	cluster, err := m.load(ref)
+	if err != nil {
+		return 0, err
+	}
+	timecode := int64(cluster.ChildUint(mkvTimecode, 0)) - m.timecodeOffset
+	if timecode < 0 {
+		timecode = 0
+	}
+	cluster.SetChild(NewUintElement(mkvTimecode, uint64(timecode)))
+
+	n, err := f.Write(cluster.Bytes())
+	return int64(n), err
+}
+
+// Open a matroska file, call fn with it and close it
+func withMatroska(filename string, fn func(m *matroskaFile) error) error {
+
+	src, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	stat, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	m, err := openMatroska(src, stat.Size())
+	if err != nil {
+		return err
+	}
+
+	return fn(m)
+}
+
+// Rewrite a matroska file after updating its elements
+func rewriteMatroska(filename string, update func(m *matroskaFile) error) error {
+	return withMatroska(filename, func(m *matroskaFile) error {
+		if err := update(m); err != nil {
+			return err
+		}
+		return replaceFile(filename, m.r, m.Write)
+	})
+}
+
+// Build Tags element from metadata
+func matroskaTags(meta Metadata) *Element {
+
+	// Target type 50 is the whole movie / album
+	tag := NewMasterElement(mkvTag, NewMasterElement(mkvTargets, NewUintElement(mkvTargetTypeValue, 50)))
+	addTag := func(name string, value string) {
+		if value != "" {
+			tag.Children = append(tag.Children, NewMasterElement(mkvSimpleTag,
+				NewStringElement(mkvTagName, name),
+				NewStringElement(mkvTagString, value)))
+		}
+	}
+
+	addTag("TITLE", meta.Title)
+	addTag("ARTIST", meta.Artist)
+	addTag("DATE_RELEASED", meta.Date)
+	addTag("DESCRIPTION", meta.Description)
+	addTag("URL", meta.Url)
+	addTag("YOUTUBE_ID", meta.VideoId)
+
+	return NewMasterElement(mkvTags, tag)
+}
+
+// Build Attachments element holding the cover art
+func matroskaAttachments(meta Metadata) *Element {
+
+	ext := "jpg"
+	if meta.CoverMime == "image/png" {
+		ext = "png"
+	}
+
+	return NewMasterElement(mkvAttachments, NewMasterElement(mkvAttachedFile,
+		NewStringElement(mkvFileDescription, "Cover"),
+		NewStringElement(mkvFileName, "cover."+ext),
+		NewStringElement(mkvFileMimeType, meta.CoverMime),
+		NewBinaryElement(mkvFileData, meta.Cover),
+		NewUintElement(mkvFileUID, uint64(crc32.ChecksumIEEE(meta.Cover))+1)))
+}
+
+// Set title, tags and cover art of the file
+func (m *matroskaFile) SetTags(meta Metadata) {
+
+	// Title displayed by players
+	if info := m.Element(mkvInfo); info != nil && meta.Title != "" {
+		info.SetChild(NewStringElement(mkvTitle, meta.Title))
+	}
+
+	m.SetElement(matroskaTags(meta))
+	if len(meta.Cover) > 0 {
+		m.SetElement(matroskaAttachments(meta))
+	}
+}
+
+// Embed metadata and cover art in a webm / mkv file, without re-encoding
+func EmbedMatroskaMetadata(filename string, meta Metadata) error {
+	return rewriteMatroska(filename, func(m *matroskaFile) error {
+		m.SetTags(meta)
+		return nil
+	})
+}
+
+// Timecode of a cluster, read without loading its blocks
+func (m *matroskaFile) clusterTimecode(ref ebmlRef) (int64, error) {
+
+	r := ref.reader(m.r)
+	_, _, offset, err := readElementHeader(r, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	for offset < ref.Size {
+		id, size, headerSize, err := readElementHeader(r, offset)
+		if err != nil {
+			return 0, err
+		}
+		if size == ebmlUnknownSize {
+			break
+		}
+		if id == mkvTimecode {
+			data := make([]byte, size)
+			if _, err := r.ReadAt(data, offset+headerSize); err != nil {
+				return 0, err
+			}
+			return int64((&Element{Data: data}).Uint()), nil
+		}
+		offset += headerSize + int64(size)
+	}
+
+	return 0, fmt.Errorf("cluster without timecode")
+}
+
+// Add a subtitle track, its blocks are put in new clusters between the existing ones
+func (m *matroskaFile) AddSubtitleTrack(codecId string, lang string, name string, cues []Cue) error {
+
+	tracks := m.Element(mkvTracks)
+	if tracks == nil {
+		return fmt.Errorf("no tracks found")
+	}
+
+	var number uint64
+	for _, track := range m.Tracks() {
+		if n := track.ChildUint(mkvTrackNumber, 0); n > number {
+			number = n
+		}
+	}
+	number++
+
+	tracks.Children = append(tracks.Children, NewMasterElement(mkvTrackEntry,
+		NewUintElement(mkvTrackNumber, number),
+		NewUintElement(mkvTrackUID, number<<32|uint64(crc32.ChecksumIEEE([]byte(lang+name)))),
+		NewUintElement(mkvTrackType, 0x11),
+		NewUintElement(mkvFlagDefault, 0),
+		NewUintElement(mkvFlagLacing, 0),
+		NewStringElement(mkvName, name),
+		NewStringElement(mkvLanguage, iso639Code(lang, true)),
+		NewStringElement(mkvLanguageBCP47, lang),
+		NewStringElement(mkvCodecId, codecId)))
+
+	timecodes := make([]int64, len(m.Clusters))
+	for i, ref := range m.Clusters {
+		var err error
+		if timecodes[i], err = m.clusterTimecode(ref); err != nil {
+			return err
+		}
+	}
+
+	// Cues go after clusters starting before them
+	// Block timecodes are relative to the cluster on 16 bits
+	scale := m.TimecodeScale()
+	var clusters []ebmlRef
+	var cluster *Element
+	var clusterTimecode int64
+	flush := func() {
+		if cluster != nil {
+			data := cluster.Bytes()
+			clusters = append(clusters, ebmlRef{Size: int64(len(data)), Data: data})
+			cluster = nil
+		}
+	}
+
+	next := 0
+	for _, cue := range cues {
+
+		timecode := int64(cue.Start) / scale
+		for next < len(m.Clusters) && timecodes[next] <= timecode {
+			flush()
+			clusters = append(clusters, m.Clusters[next])
+			next++
+		}
+		if cluster != nil && timecode-clusterTimecode > math.MaxInt16 {
+			flush()
+		}
+		if cluster == nil {
+			cluster = NewMasterElement(mkvCluster, NewUintElement(mkvTimecode, uint64(timecode)))
+			clusterTimecode = timecode
+		}
+
+		text := cue.Text
+		if codecId != "S_TEXT/UTF8" {
+			text = escapeVTT(text)
+		}
+		block := append(encodeVint(number), 0, 0, 0)
+		binary.BigEndian.PutUint16(block[len(block)-3:], uint16(timecode-clusterTimecode))
+		cluster.Children = append(cluster.Children, NewMasterElement(mkvBlockGroup,
+			NewBinaryElement(mkvBlock, append(block, text...)),
+			NewUintElement(mkvBlockDuration, uint64(int64(cue.End-cue.Start)/scale))))
+	}
+	flush()
+	m.Clusters = append(clusters, m.Clusters[next:]...)
+
+	return nil
+}
+
+// Build Chapters element with a single edition
+func matroskaChapters(chapters []Chapter) *Element {
+
+	edition := NewMasterElement(mkvEditionEntry, NewUintElement(mkvEditionUID, 1))
+	for i, chapter := range chapters {
+		atom := NewMasterElement(mkvChapterAtom,
+			NewUintElement(mkvChapterUID, uint64(i+1)),
+			NewUintElement(mkvChapterTimeStart, uint64(chapter.Start())))
+		// End is optional, open chapters last until the end of the file
+		if chapter.EndTime > chapter.StartTime {
+			atom.Children = append(atom.Children, NewUintElement(mkvChapterTimeEnd, uint64(chapter.End())))
+		}
+		atom.Children = append(atom.Children, NewMasterElement(mkvChapterDisplay,
+			NewStringElement(mkvChapString, chapter.Title),
+			NewStringElement(mkvChapLanguage, "und")))
+		edition.Children = append(edition.Children, atom)
+	}
+
+	return NewMasterElement(mkvChapters, edition)
+}
+
+// Embed subtitles and chapters in a webm / mkv file, tags are updated in the same pass
+// WebM only allows WebVTT subtitles
+func EmbedMatroskaTracks(filename string, ext string, subtitles []SubtitleTrack, chapters []Chapter, meta *Metadata) error {
+
+	codecId := "S_TEXT/UTF8"
+	if ext == "webm" {
+		codecId = "D_WEBVTT/SUBTITLES"
+	}
+
+	return rewriteMatroska(filename, func(m *matroskaFile) error {
+		for _, subtitle := range subtitles {
+			if err := m.AddSubtitleTrack(codecId, subtitle.Lang, subtitle.Name, subtitle.Cues); err != nil {
+				return err
+			}
+		}
+		if len(chapters) > 0 {
+			m.SetElement(matroskaChapters(chapters))
+		}
+		if meta != nil {
+			m.SetTags(*meta)
+		}
+		return nil
+	})
+}
+
+// Copy of the file with clusters starting between two timecodes
+// Timecodes of the copy start at zero, chapters are dropped
+func (m *matroskaFile) Cut(from int64, to int64, timecodes []int64) *matroskaFile {
+
+	part := &matroskaFile{r: m.r, header: m.header, segmentData: m.segmentData, timecodeOffset: from}
+	for _, element := range m.Elements {
+		if element.Id != mkvChapters {
+			part.Elements = append(part.Elements, element.Copy())
+		}
+	}
+
+	kept := make(map[uint64]bool)
+	for i, ref := range m.Clusters {
+		if timecodes[i] >= from && timecodes[i] < to {
+			part.Clusters = append(part.Clusters, ref)
+			if ref.Data == nil {
+				kept[uint64(ref.Offset-m.segmentData)] = true
+			}
+		}
+	}
+
+	// Cue points of kept clusters
+	if m.Cues != nil {
+		cues := NewMasterElement(mkvCues)
+		for _, cuePoint := range m.Cues.ChildrenById(mkvCuePoint) {
+			trackPositions := cuePoint.Child(mkvCueTrackPositions)
+			if trackPositions == nil || !kept[trackPositions.ChildUint(mkvCueClusterPosition, 0)] {
+				continue
+			}
+			cuePoint = cuePoint.Copy()
+			cuePoint.SetChild(NewUintElement(mkvCueTime, cuePoint.ChildUint(mkvCueTime, 0)-uint64(from)))
+			cues.Children = append(cues.Children, cuePoint)
+		}
+		if len(cues.Children) > 0 {
+			part.Cues = cues
+		}
+	}
+
+	// Duration of the part
+	if info := part.Element(mkvInfo); info != nil {
+		if duration := info.Child(mkvDuration); duration != nil {
+			end := duration.Float()
+			if float64(to) < end {
+				end = float64(to)
+			}
+			info.SetChild(NewFloatElement(mkvDuration, end-float64(from)))
+		}
+	}
+
+	return part
+}
+
+// Split a matroska file in chapters written to files
+// Chapters are cut on the cluster preceding their start, indexed clusters start with a keyframe
+func SplitMatroska(filename string, chapters []Chapter, files []string) error {
+	return withMatroska(filename, func(m *matroskaFile) error {
+
+		timecodes := make([]int64, len(m.Clusters))
+		for i, ref := range m.Clusters {
+			var err error
+			if timecodes[i], err = m.clusterTimecode(ref); err != nil {
+				return err
+			}
+		}
+
+		indexed := make(map[uint64]bool)
+		if m.Cues != nil {
+			for _, cuePoint := range m.Cues.ChildrenById(mkvCuePoint) {
+				for _, trackPositions := range cuePoint.ChildrenById(mkvCueTrackPositions) {
+					indexed[trackPositions.ChildUint(mkvCueClusterPosition, 0)] = true
+				}
+			}
+		}
+		var points []float64
+		for i, ref := range m.Clusters {
+			if len(indexed) == 0 || indexed[uint64(ref.Offset-m.segmentData)] {
+				points = append(points, float64(timecodes[i]))
+			}
+		}
+
+		scale := m.TimecodeScale()
+		cuts := make([]int64, len(chapters)+1)
+		for i, chapter := range chapters {
+			cuts[i] = int64(cutTime(points, float64(chapter.Start())/float64(scale)))
+		}
+		cuts[len(chapters)] = math.MaxInt64
+
+		for i := range chapters {
+			part := m.Cut(cuts[i], cuts[i+1], timecodes)
+			if err := writeFileAtomic(files[i], part.Write); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
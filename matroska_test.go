@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Build a minimal webm with one opus track, one cluster and cues before it
+func buildTestWebm() []byte {
+
+	header := NewMasterElement(mkvEBML, NewStringElement(0x4282, "webm"))
+	info := NewMasterElement(mkvInfo, NewUintElement(mkvTimecodeScale, 1000000))
+	tracks := NewMasterElement(mkvTracks, NewMasterElement(mkvTrackEntry,
+		NewUintElement(mkvTrackNumber, 1),
+		NewUintElement(mkvTrackType, 2),
+		NewStringElement(mkvCodecId, "A_OPUS")))
+
+	// Two 20ms CELT frames
+	block := []byte{0x81, 0, 0, 0x80, 0xfc, 1, 2, 3}
+	cluster := NewMasterElement(mkvCluster,
+		NewUintElement(mkvTimecode, 0),
+		NewBinaryElement(mkvSimpleBlock, block),
+		NewBinaryElement(mkvSimpleBlock, block))
+
+	cues := NewMasterElement(mkvCues, NewMasterElement(mkvCuePoint,
+		NewUintElement(mkvCueTime, 0),
+		NewMasterElement(mkvCueTrackPositions,
+			NewUintElement(mkvCueTrack, 1),
+			NewUintElement(mkvCueClusterPosition, 0))))
+	clusterPosition := info.Size() + tracks.Size() + cues.Size()
+	cues.Children[0].Children[1].Children[1] = NewUintElement(mkvCueClusterPosition, clusterPosition)
+
+	segment := NewMasterElement(mkvSegment, info, tracks, cues, cluster)
+	return append(header.Bytes(), segment.Bytes()...)
+}
+
+func TestEmbedMatroskaMetadata(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gotubedl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "audio.webm")
+	if err := ioutil.WriteFile(filename, buildTestWebm(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	meta := Metadata{Title: "Never Gonna Give You Up", Artist: "RickAstleyVEVO"}
+	if err := EmbedMatroskaMetadata(filename, meta); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, _ := ioutil.ReadFile(filename)
+	elements, err := ParseElements(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	segment := elements[1]
+
+	// Tags are written
+	tag := segment.Child(mkvTags).Child(mkvTag).ChildrenById(mkvSimpleTag)[0]
+	if tag.ChildString(mkvTagName) != "TITLE" || tag.ChildString(mkvTagString) != meta.Title {
+		t.Errorf("title not written")
+	}
+
+	// Cues still point to the cluster
+	var dataStart int
+	for i := range raw {
+		if bytes.HasPrefix(raw[i:], encodeElementId(mkvSegment)) {
+			dataStart = i + 12
+			break
+		}
+	}
+	position := segment.Child(mkvCues).Child(mkvCuePoint).Child(mkvCueTrackPositions).Child(mkvCueClusterPosition).Uint()
+	if !bytes.HasPrefix(raw[dataStart+int(position):], encodeElementId(mkvCluster)) {
+		t.Errorf("cue position not updated")
+	}
+}
+
+func TestEmbedMatroskaSubtitles(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gotubedl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "video.webm")
+	if err := ioutil.WriteFile(filename, buildTestWebm(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	subtitles := []SubtitleTrack{{
+		Subtitle: Subtitle{Lang: "fr-CA", Name: "French"},
+		Cues:     []Cue{{Start: time.Second, End: 2 * time.Second, Text: "Bonjour"}, {Start: 40 * time.Second, End: 41 * time.Second, Text: "A & B"}},
+	}}
+	if err := EmbedMatroskaTracks(filename, "webm", subtitles, nil, &Metadata{Title: "Never Gonna Give You Up"}); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	stat, _ := f.Stat()
+
+	m, err := openMatroska(f, stat.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tracks := m.Tracks()
+	if len(tracks) != 2 {
+		t.Fatalf("expected 2 tracks, got %d", len(tracks))
+	}
+	track := tracks[1]
+	if track.ChildString(mkvCodecId) != "D_WEBVTT/SUBTITLES" || track.ChildString(mkvLanguage) != "fre" || track.ChildUint(mkvTrackNumber, 0) != 2 {
+		t.Errorf("bad subtitle track")
+	}
+	if m.Element(mkvTags) == nil {
+		t.Errorf("tags not written")
+	}
+
+	// Cues are split in two clusters, after the audio one
+	if len(m.Clusters) != 3 {
+		t.Errorf("expected 3 clusters, got %d", len(m.Clusters))
+	}
+	var texts []string
+	var timecodes []int64
+	err = m.ReadBlocks(func(block Block) error {
+		if block.Track == 2 {
+			texts = append(texts, string(block.Frames[0]))
+			timecodes = append(timecodes, block.Timecode)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(texts) != 2 || texts[0] != "Bonjour" || texts[1] != "A &amp; B" {
+		t.Errorf("bad subtitle blocks %q", texts)
+	}
+	if len(timecodes) == 2 && (timecodes[0] != 1000 || timecodes[1] != 40000) {
+		t.Errorf("bad subtitle timecodes %v", timecodes)
+	}
+}
+
+func TestSplitMatroska(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gotubedl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Three clusters of one second
+	header := NewMasterElement(mkvEBML, NewStringElement(0x4282, "webm"))
+	segment := NewMasterElement(mkvSegment,
+		NewMasterElement(mkvInfo, NewUintElement(mkvTimecodeScale, 1000000), NewFloatElement(mkvDuration, 3000)),
+		NewMasterElement(mkvTracks, NewMasterElement(mkvTrackEntry,
+			NewUintElement(mkvTrackNumber, 1),
+			NewUintElement(mkvTrackType, 2),
+			NewStringElement(mkvCodecId, "A_OPUS"))))
+	for _, timecode := range []uint64{0, 1000, 2000} {
+		segment.Children = append(segment.Children, NewMasterElement(mkvCluster,
+			NewUintElement(mkvTimecode, timecode),
+			NewBinaryElement(mkvSimpleBlock, []byte{0x81, 0, 0, 0x80, byte(timecode / 1000)})))
+	}
+
+	filename := filepath.Join(dir, "audio.webm")
+	if err := ioutil.WriteFile(filename, append(header.Bytes(), segment.Bytes()...), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	chapters := []Chapter{{Title: "One", StartTime: 0, EndTime: 1.5}, {Title: "Two", StartTime: 1.5, EndTime: 3}}
+	if err := EmbedMatroskaTracks(filename, "webm", nil, chapters, nil); err != nil {
+		t.Fatal(err)
+	}
+	files := []string{filepath.Join(dir, "one.webm"), filepath.Join(dir, "two.webm")}
+	if err := SplitMatroska(filename, chapters, files); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		Timecodes []int64
+		Duration  float64
+	}{{[]int64{0}, 1000}, {[]int64{0, 1000}, 2000}}
+
+	for i, file := range files {
+
+		f, err := os.Open(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		stat, _ := f.Stat()
+
+		m, err := openMatroska(f, stat.Size())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Element(mkvChapters) != nil {
+			t.Errorf("part %d: chapters not dropped", i+1)
+		}
+		if duration := m.Element(mkvInfo).Child(mkvDuration).Float(); duration != expected[i].Duration {
+			t.Errorf("part %d: bad duration %f", i+1, duration)
+		}
+
+		var timecodes []int64
+		m.ReadBlocks(func(block Block) error {
+			timecodes = append(timecodes, block.Timecode)
+			return nil
+		})
+		if len(timecodes) != len(expected[i].Timecodes) || timecodes[0] != 0 || timecodes[len(timecodes)-1] != expected[i].Timecodes[len(timecodes)-1] {
+			t.Errorf("part %d: bad timecodes %v", i+1, timecodes)
+		}
+	}
+}
+
+func TestExtractOpus(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gotubedl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "audio.webm")
+	dest := filepath.Join(dir, "audio.opus")
+	if err := ioutil.WriteFile(src, buildTestWebm(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ExtractOpus(src, dest, Metadata{Title: "Never Gonna Give You Up"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Head, tags and audio pages
+	raw, _ := ioutil.ReadFile(dest)
+	var pages [][]byte
+	for len(raw) > 27 && bytes.HasPrefix(raw, []byte("OggS")) {
+		size := 27 + int(raw[26])
+		for _, segment := range raw[27 : 27+int(raw[26])] {
+			size += int(segment)
+		}
+		pages = append(pages, raw[:size])
+		raw = raw[size:]
+	}
+	if len(pages) != 3 {
+		t.Fatalf("expected 3 pages, got %d", len(pages))
+	}
+	if !bytes.Contains(pages[0], []byte("OpusHead")) || !bytes.Contains(pages[1], []byte("TITLE=Never")) {
+		t.Errorf("bad headers")
+	}
+
+	// Two 20ms frames at 48kHz
+	last := pages[2]
+	if granule := binary.LittleEndian.Uint64(last[6:]); granule != 1920 {
+		t.Errorf("bad granule %d", granule)
+	}
+	if last[5]&0x04 == 0 {
+		t.Errorf("last page not flagged")
+	}
+}
+
+func TestOggCRC(t *testing.T) {
+	if crc := oggCRC([]byte("123456789")); crc != 0x89a1897f {
+		t.Errorf("bad crc %x", crc)
+	}
+}
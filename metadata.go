@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+)
+
+// Metadata embedded in downloaded files
+type Metadata struct {
+	Title       string
+	Artist      string
+	Description string
+	Date        string // YYYY-MM-DD
+	VideoId     string
+	Url         string
+	Cover       []byte
+	CoverMime   string // image/jpeg or image/png
+}
+
+// Build metadata from video information
+func NewMetadata(video *Video) Metadata {
+
+	// upload_date is YYYYMMDD like youtube-dl
+	date := video.UploadDate
+	if len(date) == 8 {
+		date = date[:4] + "-" + date[4:6] + "-" + date[6:]
+	}
+
+	return Metadata{
+		Title:       video.Title,
+		Artist:      video.Author,
+		Description: video.Description,
+		Date:        date,
+		VideoId:     video.VideoId,
+		Url:         video.WebpageUrl(),
+	}
+}
+
+// Load cover art from written thumbnails or from youtube
+// Cover is always jpeg or png, webp is not supported by players
+func loadCover(ctx context.Context, video *Video, thumbnails []string) (data []byte, err error) {
+
+	if len(thumbnails) > 0 {
+		data, err = ioutil.ReadFile(thumbnails[0])
+	} else {
+		for _, thumbnail := range video.Thumbnails {
+			var raw string
+			if raw, err = downloadPage(ctx, thumbnail.Url); err == nil {
+				data = []byte(raw)
+				break
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch http.DetectContentType(data) {
+	case "image/jpeg", "image/png":
+		return data, nil
+	}
+	return ConvertThumbnail(data, "jpg")
+}
+
+// Embed metadata in a downloaded file depending on its container
+func embedMetadata(filename string, ext string, meta Metadata) error {
+	switch ext {
+	case "mp4", "m4a":
+		return EmbedMP4Metadata(filename, meta)
+	case "webm", "mkv":
+		return EmbedMatroskaMetadata(filename, meta)
+	}
+	return fmt.Errorf("can't embed metadata in %s files", ext)
+}
+
+// Video information written next to downloaded files, like youtube-dl
+type videoInfo struct {
+	*Video
+	Filename string `json:"_filename"` // Base name of the downloaded file
+}
+
+// Filename of the info JSON of a downloaded file
+func infoJsonFilename(filename string) string {
+	return replaceExt(filename, "info.json")
+}
+
+// Write the information of a video next to its downloaded file
+func writeInfoJson(video *Video, filename string) error {
+
+	data, err := json.MarshalIndent(videoInfo{Video: video, Filename: filepath.Base(filename)}, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(infoJsonFilename(filename), data, 0644)
+}
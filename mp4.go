@@ -0,0 +1,468 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+// MP4 box (atom), containers hold children and leaves raw data
+type Box struct {
+	Type     string
+	Header   []byte // Version and flags of full box containers (meta)
+	Data     []byte
+	Children []*Box
+}
+
+// Boxes containing only other boxes
+var mp4Containers = map[string]bool{
+	"moov": true, "trak": true, "mdia": true, "minf": true, "stbl": true,
+	"udta": true, "dinf": true, "edts": true, "mvex": true, "moof": true,
+	"traf": true, "mfra": true, "meta": true, "ilst": true,
+}
+
+// Items of the ilst box contain data boxes
+func isContainer(boxType string, parent string) bool {
+	return mp4Containers[boxType] || parent == "ilst"
+}
+
+// Create a leaf box
+func NewBox(boxType string, data []byte) *Box {
+	return &Box{Type: boxType, Data: data}
+}
+
+// Create a container box
+func NewContainer(boxType string, children ...*Box) *Box {
+	return &Box{Type: boxType, Children: children}
+}
+
+// Parse a sequence of boxes
+func ParseBoxes(data []byte, parent string) ([]*Box, error) {
+
+	var boxes []*Box
+	for len(data) > 0 {
+
+		if len(data) < 8 {
+			return nil, fmt.Errorf("truncated box in %s", parent)
+		}
+
+		size := uint64(binary.BigEndian.Uint32(data))
+		boxType := string(data[4:8])
+		headerSize := uint64(8)
+
+		switch size {
+		case 0: // Box extends to the end
+			size = uint64(len(data))
+		case 1: // 64 bits size
+			if len(data) < 16 {
+				return nil, fmt.Errorf("truncated box %s", boxType)
+			}
+			size = binary.BigEndian.Uint64(data[8:])
+			headerSize = 16
+		}
+
+		if size < headerSize || size > uint64(len(data)) {
+			return nil, fmt.Errorf("invalid size for box %s", boxType)
+		}
+
+		payload := data[headerSize:size]
+		box := &Box{Type: boxType}
+
+		if isContainer(boxType, parent) {
+
+			// meta is a full box, except in old quicktime files
+			if boxType == "meta" && !(len(payload) >= 8 && string(payload[4:8]) == "hdlr") {
+				if len(payload) < 4 {
+					return nil, fmt.Errorf("truncated box %s", boxType)
+				}
+				box.Header = payload[:4]
+				payload = payload[4:]
+			}
+
+			children, err := ParseBoxes(payload, boxType)
+			if err != nil {
+				return nil, err
+			}
+			box.Children = children
+		} else {
+			box.Data = payload
+		}
+
+		boxes = append(boxes, box)
+		data = data[size:]
+	}
+
+	return boxes, nil
+}
+
+// Size of the box once serialized
+func (b *Box) Size() uint64 {
+	size := uint64(len(b.Header) + len(b.Data))
+	for _, child := range b.Children {
+		size += child.Size()
+	}
+	if size+8 > math.MaxUint32 {
+		return size + 16
+	}
+	return size + 8
+}
+
+// Serialize box and its children
+func (b *Box) Bytes() []byte {
+
+	size := b.Size()
+	buf := make([]byte, 0, size)
+
+	if size > math.MaxUint32 {
+		buf = append(buf, 0, 0, 0, 1)
+		buf = append(buf, b.Type...)
+		buf = appendUint64(buf, size)
+	} else {
+		buf = appendUint32(buf, uint32(size))
+		buf = append(buf, b.Type...)
+	}
+
+	buf = append(buf, b.Header...)
+	buf = append(buf, b.Data...)
+	for _, child := range b.Children {
+		buf = append(buf, child.Bytes()...)
+	}
+
+	return buf
+}
+
+// First child of the given type
+func (b *Box) Child(boxType string) *Box {
+	for _, child := range b.Children {
+		if child.Type == boxType {
+			return child
+		}
+	}
+	return nil
+}
+
+// Find a box by its path, ex: "udta/meta/ilst"
+func (b *Box) Find(path string) *Box {
+	box := b
+	for _, boxType := range strings.Split(path, "/") {
+		box = box.Child(boxType)
+		if box == nil {
+			return nil
+		}
+	}
+	return box
+}
+
+// All boxes of the given type in the whole tree
+func (b *Box) FindAll(boxType string) []*Box {
+	var boxes []*Box
+	for _, child := range b.Children {
+		if child.Type == boxType {
+			boxes = append(boxes, child)
+		}
+		boxes = append(boxes, child.FindAll(boxType)...)
+	}
+	return boxes
+}
+
+// Remove all children of the given type
+func (b *Box) Remove(boxType string) {
+	children := b.Children[:0]
+	for _, child := range b.Children {
+		if child.Type != boxType {
+			children = append(children, child)
+		}
+	}
+	b.Children = children
+}
+
+func appendUint32(buf []byte, v uint32) []byte {
+	return append(buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint64(buf []byte, v uint64) []byte {
+	return appendUint32(appendUint32(buf, uint32(v>>32)), uint32(v))
+}
+
+// Top level box of a file, payload stays on disk
+type fileBox struct {
+	Type       string
+	Offset     int64
+	HeaderSize int64
+	Size       int64
+}
+
+// List top level boxes of a mp4 file
+func scanBoxes(r io.ReaderAt, fileSize int64) ([]fileBox, error) {
+
+	var boxes []fileBox
+	header := make([]byte, 16)
+
+	for offset := int64(0); offset < fileSize; {
+
+		if _, err := r.ReadAt(header[:8], offset); err != nil {
+			return nil, err
+		}
+
+		box := fileBox{
+			Type:       string(header[4:8]),
+			Offset:     offset,
+			HeaderSize: 8,
+			Size:       int64(binary.BigEndian.Uint32(header)),
+		}
+
+		switch box.Size {
+		case 0:
+			box.Size = fileSize - offset
+		case 1:
+			if _, err := r.ReadAt(header[8:16], offset+8); err != nil {
+				return nil, err
+			}
+			box.Size = int64(binary.BigEndian.Uint64(header[8:]))
+			box.HeaderSize = 16
+		}
+
+		if box.Size < box.HeaderSize || offset+box.Size > fileSize {
+			return nil, fmt.Errorf("invalid size for box %s", box.Type)
+		}
+
+		boxes = append(boxes, box)
+		offset += box.Size
+	}
+
+	return boxes, nil
+}
+
+// Load a top level box and its children in memory
+func loadBox(r io.ReaderAt, fb fileBox) (*Box, error) {
+	raw := make([]byte, fb.Size)
+	if _, err := r.ReadAt(raw, fb.Offset); err != nil {
+		return nil, err
+	}
+	boxes, err := ParseBoxes(raw, "")
+	if err != nil {
+		return nil, err
+	}
+	return boxes[0], nil
+}
+
+// Shift absolute offsets pointing after pos
+// Used when media data is moved because the moov box changed size
+func shiftOffsets(box *Box, pos int64, delta int64) error {
+
+	// Chunk offsets of progressive files
+	for _, stco := range box.FindAll("stco") {
+		if len(stco.Data) < 8 {
+			return fmt.Errorf("invalid stco box")
+		}
+		count := int(binary.BigEndian.Uint32(stco.Data[4:]))
+		if count < 0 || count > (len(stco.Data)-8)/4 {
+			return fmt.Errorf("invalid stco entry count %d", count)
+		}
+		for i := 0; i < count; i++ {
+			entry := stco.Data[8+i*4:]
+			offset := int64(binary.BigEndian.Uint32(entry))
+			if offset < pos {
+				continue
+			}
+			if offset+delta > math.MaxUint32 {
+				return fmt.Errorf("chunk offset overflow")
+			}
+			binary.BigEndian.PutUint32(entry, uint32(offset+delta))
+		}
+	}
+
+	for _, co64 := range box.FindAll("co64") {
+		if len(co64.Data) < 8 {
+			return fmt.Errorf("invalid co64 box")
+		}
+		count := int(binary.BigEndian.Uint32(co64.Data[4:]))
+		if count < 0 || count > (len(co64.Data)-8)/8 {
+			return fmt.Errorf("invalid co64 entry count %d", count)
+		}
+		for i := 0; i < count; i++ {
+			entry := co64.Data[8+i*8:]
+			offset := int64(binary.BigEndian.Uint64(entry))
+			if offset >= pos {
+				binary.BigEndian.PutUint64(entry, uint64(offset+delta))
+			}
+		}
+	}
+
+	// Fragments with explicit base data offset
+	for _, tfhd := range box.FindAll("tfhd") {
+		if len(tfhd.Data) < 4 {
+			return fmt.Errorf("invalid tfhd box")
+		}
+		flags := binary.BigEndian.Uint32(tfhd.Data) & 0xffffff
+		if flags&0x1 == 0 {
+			continue
+		}
+		if len(tfhd.Data) < 16 {
+			return fmt.Errorf("invalid tfhd box")
+		}
+		entry := tfhd.Data[8:]
+		offset := int64(binary.BigEndian.Uint64(entry))
+		if offset >= pos {
+			binary.BigEndian.PutUint64(entry, uint64(offset+delta))
+		}
+	}
+
+	return nil
+}
+
+// Rewrite a mp4 file after updating its moov box
+// Media data is copied as is, only offsets are updated
+func rewriteMP4(filename string, update func(moov *Box) error) error {
+
+	src, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	stat, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	boxes, err := scanBoxes(src, stat.Size())
+	if err != nil {
+		return err
+	}
+
+	// Find and update moov
+	var moov *Box
+	var moovEnd, delta int64
+	for _, fb := range boxes {
+		if fb.Type != "moov" {
+			continue
+		}
+		if moov, err = loadBox(src, fb); err != nil {
+			return err
+		}
+		if err := update(moov); err != nil {
+			return err
+		}
+		moovEnd = fb.Offset + fb.Size
+		delta = int64(moov.Size()) - fb.Size
+		break
+	}
+	if moov == nil {
+		return fmt.Errorf("no moov box in %s", filename)
+	}
+
+	// Data after moov moves by the size difference
+	if err := shiftOffsets(moov, moovEnd, delta); err != nil {
+		return err
+	}
+
+	return replaceFile(filename, src, func(dst *os.File) error {
+		for _, fb := range boxes {
+			var err error
+			switch {
+			case fb.Type == "moov":
+				_, err = dst.Write(moov.Bytes())
+			case fb.Type == "moof" && fb.Offset >= moovEnd && delta != 0:
+				var moof *Box
+				moof, err = loadBox(src, fb)
+				if err == nil {
+					err = shiftOffsets(moof, moovEnd, delta)
+				}
+				if err == nil {
+					_, err = dst.Write(moof.Bytes())
+				}
+			default:
+				_, err = io.Copy(dst, io.NewSectionReader(src, fb.Offset, fb.Size))
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Data box of an ilst item
+func mp4DataBox(dataType uint32, value []byte) *Box {
+	data := make([]byte, 8, 8+len(value))
+	binary.BigEndian.PutUint32(data, dataType)
+	return NewBox("data", append(data, value...))
+}
+
+// Text item of an ilst box
+func mp4TextItem(itemType string, value string) *Box {
+	return NewContainer(itemType, mp4DataBox(1, []byte(value)))
+}
+
+// Freeform item of an ilst box, stored under com.apple.iTunes
+func mp4FreeformItem(name string, value string) *Box {
+	return NewContainer("----",
+		NewBox("mean", append([]byte{0, 0, 0, 0}, "com.apple.iTunes"...)),
+		NewBox("name", append([]byte{0, 0, 0, 0}, name...)),
+		mp4DataBox(1, []byte(value)))
+}
+
+// Build the udta/meta box holding iTunes style tags
+func mp4MetaBox(meta Metadata) *Box {
+
+	// Handler telling it's an iTunes metadata box
+	hdlr := make([]byte, 25)
+	copy(hdlr[8:], "mdir")
+	copy(hdlr[12:], "appl")
+
+	ilst := NewContainer("ilst")
+	addText := func(itemType string, value string) {
+		if value != "" {
+			ilst.Children = append(ilst.Children, mp4TextItem(itemType, value))
+		}
+	}
+
+	addText("\xa9nam", meta.Title)
+	addText("\xa9ART", meta.Artist)
+	addText("\xa9day", meta.Date)
+	addText("desc", meta.Description)
+	addText("ldes", meta.Description)
+	addText("\xa9cmt", meta.Url)
+	addText("purl", meta.Url)
+	if meta.VideoId != "" {
+		ilst.Children = append(ilst.Children, mp4FreeformItem("YOUTUBE_ID", meta.VideoId))
+	}
+
+	// Cover art, jpeg or png only
+	if len(meta.Cover) > 0 {
+		dataType := uint32(13)
+		if meta.CoverMime == "image/png" {
+			dataType = 14
+		}
+		ilst.Children = append(ilst.Children, NewContainer("covr", mp4DataBox(dataType, meta.Cover)))
+	}
+
+	box := NewContainer("meta", NewBox("hdlr", hdlr), ilst)
+	box.Header = []byte{0, 0, 0, 0}
+	return box
+}
+
+// Replace tags of a moov box
+func setMP4Tags(moov *Box, meta Metadata) {
+
+	udta := moov.Child("udta")
+	if udta == nil {
+		udta = NewContainer("udta")
+		moov.Children = append(moov.Children, udta)
+	}
+
+	udta.Remove("meta")
+	udta.Children = append(udta.Children, mp4MetaBox(meta))
+}
+
+// Embed metadata and cover art in a mp4 / m4a file, without re-encoding
+func EmbedMP4Metadata(filename string, meta Metadata) error {
+	return rewriteMP4(filename, func(moov *Box) error {
+		setMP4Tags(moov, meta)
+		return nil
+	})
+}
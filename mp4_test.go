@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Build a minimal progressive mp4 with one chunk of data after moov
+func buildTestMP4() []byte {
+
+	ftyp := NewBox("ftyp", []byte("isom\x00\x00\x02\x00isomiso2mp41"))
+	stco := NewBox("stco", make([]byte, 12))
+	binary.BigEndian.PutUint32(stco.Data[4:], 1)
+	moov := NewContainer("moov",
+		NewContainer("trak", NewContainer("mdia", NewContainer("minf", NewContainer("stbl", stco)))))
+	mdat := NewBox("mdat", []byte("never gonna give you up"))
+
+	// Chunk starts right after mdat header
+	offset := ftyp.Size() + moov.Size() + 8
+	binary.BigEndian.PutUint32(stco.Data[8:], uint32(offset))
+
+	var buf bytes.Buffer
+	buf.Write(ftyp.Bytes())
+	buf.Write(moov.Bytes())
+	buf.Write(mdat.Bytes())
+	return buf.Bytes()
+}
+
+func TestEmbedMP4Metadata(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gotubedl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "video.mp4")
+	if err := ioutil.WriteFile(filename, buildTestMP4(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	meta := Metadata{Title: "Never Gonna Give You Up", Artist: "RickAstleyVEVO", VideoId: videoId}
+	if err := EmbedMP4Metadata(filename, meta); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, _ := ioutil.ReadFile(filename)
+	boxes, err := ParseBoxes(raw, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Title is written
+	var moov *Box
+	for _, box := range boxes {
+		if box.Type == "moov" {
+			moov = box
+		}
+	}
+	title := moov.Find("udta/meta/ilst/\xa9nam/data")
+	if title == nil || string(title.Data[8:]) != meta.Title {
+		t.Errorf("title not written")
+	}
+
+	// Chunk offset still points to media data
+	stco := moov.FindAll("stco")[0]
+	offset := binary.BigEndian.Uint32(stco.Data[8:])
+	if !bytes.HasPrefix(raw[offset:], []byte("never gonna")) {
+		t.Errorf("chunk offset not updated")
+	}
+}
+
+func TestShiftOffsetsTruncated(t *testing.T) {
+
+	// Entry counts larger than the box data
+	stco := NewBox("stco", make([]byte, 12))
+	binary.BigEndian.PutUint32(stco.Data[4:], 3)
+	co64 := NewBox("co64", make([]byte, 12))
+	binary.BigEndian.PutUint32(co64.Data[4:], 1)
+	tfhd := NewBox("tfhd", []byte{0, 0, 0, 1, 0, 0, 0, 1})
+
+	for _, box := range []*Box{stco, co64, tfhd, NewBox("stco", nil)} {
+		if err := shiftOffsets(NewContainer("moov", box), 0, 8); err == nil {
+			t.Errorf("expected error for truncated %s", box.Type)
+		}
+	}
+}
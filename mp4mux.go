@@ -0,0 +1,1025 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"time"
+)
+
+// Sample of a mp4 track
+type mp4Sample struct {
+	Offset            int64  // Offset of the data in source file
+	Data              []byte // Data of samples not coming from a file
+	Size              uint32
+	Duration          uint32
+	CompositionOffset int32
+	Sync              bool
+}
+
+// Track of a mp4 file and its samples
+type mp4Track struct {
+	Id        uint32
+	Timescale uint32
+	Trak      *Box
+	Samples   []mp4Sample
+}
+
+// Mp4 file split in tracks, progressive or fragmented
+type mp4File struct {
+	r      io.ReaderAt
+	Moov   *Box
+	Tracks []*mp4Track
+}
+
+// Read big endian values from box data
+type boxReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *boxReader) bytes(n int) []byte {
+	if r.err != nil || r.pos+n > len(r.data) {
+		r.err = fmt.Errorf("truncated box")
+		return make([]byte, n)
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
+
+func (r *boxReader) uint32() uint32 {
+	return binary.BigEndian.Uint32(r.bytes(4))
+}
+
+func (r *boxReader) uint64() uint64 {
+	return binary.BigEndian.Uint64(r.bytes(8))
+}
+
+// Version and flags of a full box
+func (r *boxReader) fullBox() (version byte, flags uint32) {
+	v := r.uint32()
+	return byte(v >> 24), v & 0xffffff
+}
+
+// Offsets of values in mvhd, tkhd and mdhd, for version 0 and 1
+var mp4HeaderOffsets = map[string]struct {
+	Timescale [2]int
+	Duration  [2]int
+	TrackId   [2]int
+}{
+	"mvhd": {Timescale: [2]int{12, 20}, Duration: [2]int{16, 24}},
+	"mdhd": {Timescale: [2]int{12, 20}, Duration: [2]int{16, 24}},
+	"tkhd": {Duration: [2]int{20, 28}, TrackId: [2]int{12, 20}},
+}
+
+// Read a value of a header box (mvhd, tkhd, mdhd)
+func headerValue(box *Box, offsets [2]int, is64 bool) uint64 {
+	if box == nil || len(box.Data) == 0 {
+		return 0
+	}
+	version := int(box.Data[0] & 1)
+	r := &boxReader{data: box.Data, pos: offsets[version]}
+	if is64 && version == 1 {
+		return r.uint64()
+	}
+	return uint64(r.uint32())
+}
+
+// Duration of a header box
+func boxDuration(box *Box) uint64 {
+	return headerValue(box, mp4HeaderOffsets[box.Type].Duration, true)
+}
+
+// Set duration of a header box
+func setBoxDuration(box *Box, duration uint64) {
+	if box == nil || len(box.Data) == 0 {
+		return
+	}
+	version := int(box.Data[0] & 1)
+	offset := mp4HeaderOffsets[box.Type].Duration[version]
+	if version == 1 && offset+8 <= len(box.Data) {
+		binary.BigEndian.PutUint64(box.Data[offset:], duration)
+	} else if offset+4 <= len(box.Data) {
+		if duration > math.MaxUint32 {
+			duration = math.MaxUint32
+		}
+		binary.BigEndian.PutUint32(box.Data[offset:], uint32(duration))
+	}
+}
+
+// Parse a mp4 file and the sample tables of its tracks
+func openMP4(r io.ReaderAt, fileSize int64) (*mp4File, error) {
+
+	boxes, err := scanBoxes(r, fileSize)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &mp4File{r: r}
+	for _, fb := range boxes {
+		if fb.Type == "moov" {
+			if m.Moov, err = loadBox(r, fb); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	if m.Moov == nil {
+		return nil, fmt.Errorf("no moov box found")
+	}
+
+	// Progressive samples
+	for _, trak := range m.Moov.FindAll("trak") {
+		mdhd := trak.Find("mdia/mdhd")
+		track := &mp4Track{
+			Id:        uint32(headerValue(trak.Child("tkhd"), mp4HeaderOffsets["tkhd"].TrackId, false)),
+			Timescale: uint32(headerValue(mdhd, mp4HeaderOffsets["mdhd"].Timescale, false)),
+			Trak:      trak,
+		}
+		if err := track.readSampleTable(); err != nil {
+			return nil, err
+		}
+		m.Tracks = append(m.Tracks, track)
+	}
+
+	// Fragmented samples
+	for _, fb := range boxes {
+		if fb.Type != "moof" {
+			continue
+		}
+		moof, err := loadBox(r, fb)
+		if err != nil {
+			return nil, err
+		}
+		if err := m.readFragment(moof, fb.Offset); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// Track with the given id
+func (m *mp4File) Track(id uint32) *mp4Track {
+	for _, track := range m.Tracks {
+		if track.Id == id {
+			return track
+		}
+	}
+	return nil
+}
+
+// Read samples described in the stbl box
+func (t *mp4Track) readSampleTable() error {
+
+	stbl := t.Trak.Find("mdia/minf/stbl")
+	if stbl == nil {
+		return fmt.Errorf("no sample table for track %d", t.Id)
+	}
+
+	// Sizes
+	stsz := stbl.Child("stsz")
+	if stsz == nil {
+		return nil
+	}
+	r := &boxReader{data: stsz.Data}
+	r.fullBox()
+	sampleSize := r.uint32()
+	count := int(r.uint32())
+	if r.err != nil || (sampleSize == 0 && len(stsz.Data) < 12+count*4) {
+		return fmt.Errorf("invalid stsz box")
+	}
+	t.Samples = make([]mp4Sample, count)
+	for i := range t.Samples {
+		t.Samples[i].Size = sampleSize
+		if sampleSize == 0 {
+			t.Samples[i].Size = r.uint32()
+		}
+		t.Samples[i].Sync = true
+	}
+
+	// Chunk offsets
+	var chunkOffsets []int64
+	if stco := stbl.Child("stco"); stco != nil {
+		r = &boxReader{data: stco.Data}
+		r.fullBox()
+		for n := r.uint32(); n > 0 && r.err == nil; n-- {
+			chunkOffsets = append(chunkOffsets, int64(r.uint32()))
+		}
+	} else if co64 := stbl.Child("co64"); co64 != nil {
+		r = &boxReader{data: co64.Data}
+		r.fullBox()
+		for n := r.uint32(); n > 0 && r.err == nil; n-- {
+			chunkOffsets = append(chunkOffsets, int64(r.uint64()))
+		}
+	}
+	if r.err != nil {
+		return r.err
+	}
+
+	// Samples per chunk
+	type stscEntry struct{ FirstChunk, Samples uint32 }
+	var stsc []stscEntry
+	if box := stbl.Child("stsc"); box != nil {
+		r = &boxReader{data: box.Data}
+		r.fullBox()
+		for n := r.uint32(); n > 0 && r.err == nil; n-- {
+			stsc = append(stsc, stscEntry{r.uint32(), r.uint32()})
+			r.uint32() // Sample description index
+		}
+	}
+	if r.err != nil {
+		return r.err
+	}
+
+	sample := 0
+	for chunk := range chunkOffsets {
+		perChunk := uint32(0)
+		for _, entry := range stsc {
+			if entry.FirstChunk <= uint32(chunk+1) {
+				perChunk = entry.Samples
+			}
+		}
+		offset := chunkOffsets[chunk]
+		for i := uint32(0); i < perChunk && sample < count; i++ {
+			t.Samples[sample].Offset = offset
+			offset += int64(t.Samples[sample].Size)
+			sample++
+		}
+	}
+
+	// Durations
+	if stts := stbl.Child("stts"); stts != nil {
+		r = &boxReader{data: stts.Data}
+		r.fullBox()
+		sample = 0
+		for n := r.uint32(); n > 0 && r.err == nil; n-- {
+			sampleCount, delta := r.uint32(), r.uint32()
+			for i := uint32(0); i < sampleCount && sample < count; i++ {
+				t.Samples[sample].Duration = delta
+				sample++
+			}
+		}
+	}
+
+	// Composition offsets
+	if ctts := stbl.Child("ctts"); ctts != nil {
+		r = &boxReader{data: ctts.Data}
+		r.fullBox()
+		sample = 0
+		for n := r.uint32(); n > 0 && r.err == nil; n-- {
+			sampleCount, offset := r.uint32(), int32(r.uint32())
+			for i := uint32(0); i < sampleCount && sample < count; i++ {
+				t.Samples[sample].CompositionOffset = offset
+				sample++
+			}
+		}
+	}
+
+	// Sync samples, all samples are sync when missing
+	if stss := stbl.Child("stss"); stss != nil {
+		for i := range t.Samples {
+			t.Samples[i].Sync = false
+		}
+		r = &boxReader{data: stss.Data}
+		r.fullBox()
+		for n := r.uint32(); n > 0 && r.err == nil; n-- {
+			if number := int(r.uint32()); number >= 1 && number <= count {
+				t.Samples[number-1].Sync = true
+			}
+		}
+	}
+
+	return r.err
+}
+
+// Default values of fragments samples, from trex
+func (m *mp4File) trackDefaults(trackId uint32) (duration uint32, size uint32, flags uint32) {
+	mvex := m.Moov.Child("mvex")
+	if mvex == nil {
+		return
+	}
+	for _, trex := range mvex.FindAll("trex") {
+		r := &boxReader{data: trex.Data}
+		r.fullBox()
+		if r.uint32() != trackId {
+			continue
+		}
+		r.uint32() // Sample description index
+		return r.uint32(), r.uint32(), r.uint32()
+	}
+	return
+}
+
+// Read samples of a movie fragment
+func (m *mp4File) readFragment(moof *Box, moofOffset int64) error {
+
+	for _, traf := range moof.FindAll("traf") {
+
+		tfhd := traf.Child("tfhd")
+		if tfhd == nil {
+			continue
+		}
+
+		r := &boxReader{data: tfhd.Data}
+		_, flags := r.fullBox()
+		track := m.Track(r.uint32())
+		if track == nil {
+			continue
+		}
+		defaultDuration, defaultSize, defaultFlags := m.trackDefaults(track.Id)
+
+		// Data offsets are relative to the moof box unless told otherwise
+		base := moofOffset
+		if flags&0x1 != 0 {
+			base = int64(r.uint64())
+		}
+		if flags&0x2 != 0 {
+			r.uint32() // Sample description index
+		}
+		if flags&0x8 != 0 {
+			defaultDuration = r.uint32()
+		}
+		if flags&0x10 != 0 {
+			defaultSize = r.uint32()
+		}
+		if flags&0x20 != 0 {
+			defaultFlags = r.uint32()
+		}
+		if r.err != nil {
+			return r.err
+		}
+
+		dataOffset := base
+		for _, trun := range traf.FindAll("trun") {
+
+			r = &boxReader{data: trun.Data}
+			_, trunFlags := r.fullBox()
+			count := r.uint32()
+			if trunFlags&0x1 != 0 {
+				dataOffset = base + int64(int32(r.uint32()))
+			}
+			firstFlags, hasFirstFlags := uint32(0), trunFlags&0x4 != 0
+			if hasFirstFlags {
+				firstFlags = r.uint32()
+			}
+
+			for i := uint32(0); i < count && r.err == nil; i++ {
+
+				sample := mp4Sample{Offset: dataOffset, Duration: defaultDuration, Size: defaultSize}
+				sampleFlags := defaultFlags
+				if i == 0 && hasFirstFlags {
+					sampleFlags = firstFlags
+				}
+
+				if trunFlags&0x100 != 0 {
+					sample.Duration = r.uint32()
+				}
+				if trunFlags&0x200 != 0 {
+					sample.Size = r.uint32()
+				}
+				if trunFlags&0x400 != 0 {
+					sampleFlags = r.uint32()
+				}
+				if trunFlags&0x800 != 0 {
+					sample.CompositionOffset = int32(r.uint32())
+				}
+
+				// sample_is_non_sync_sample
+				sample.Sync = sampleFlags&0x10000 == 0
+				dataOffset += int64(sample.Size)
+				track.Samples = append(track.Samples, sample)
+			}
+			if r.err != nil {
+				return r.err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Duration of the track in its timescale
+func (t *mp4Track) Duration() uint64 {
+	var duration uint64
+	for _, sample := range t.Samples {
+		duration += uint64(sample.Duration)
+	}
+	return duration
+}
+
+// Duration of the longest track
+func (m *mp4File) Duration() time.Duration {
+	var duration time.Duration
+	for _, track := range m.Tracks {
+		if track.Timescale == 0 {
+			continue
+		}
+		if d := time.Duration(float64(track.Duration()) / float64(track.Timescale) * float64(time.Second)); d > duration {
+			duration = d
+		}
+	}
+	return duration
+}
+
+// Run of samples written together
+type mp4Chunk struct {
+	Track  *mp4Track
+	First  int
+	Count  int
+	Time   float64 // Decode time of the first sample in seconds
+	Offset uint64
+}
+
+// Group samples of tracks in chunks of about one second, ordered by time
+func (m *mp4File) chunks() []*mp4Chunk {
+
+	var chunks []*mp4Chunk
+	for _, track := range m.Tracks {
+
+		timescale := uint64(track.Timescale)
+		if timescale == 0 {
+			timescale = 1
+		}
+
+		var decodeTime, chunkTime uint64
+		var chunk *mp4Chunk
+		for i, sample := range track.Samples {
+			if chunk == nil || decodeTime-chunkTime >= timescale {
+				chunk = &mp4Chunk{Track: track, First: i, Time: float64(decodeTime) / float64(timescale)}
+				chunks = append(chunks, chunk)
+				chunkTime = decodeTime
+			}
+			chunk.Count++
+			decodeTime += uint64(sample.Duration)
+		}
+	}
+
+	sort.SliceStable(chunks, func(i, j int) bool {
+		return chunks[i].Time < chunks[j].Time
+	})
+
+	return chunks
+}
+
+// Build a full box
+func newFullBox(boxType string, version byte, flags uint32, data []byte) *Box {
+	header := appendUint32(nil, uint32(version)<<24|flags)
+	return NewBox(boxType, append(header, data...))
+}
+
+// Replace the sample table of a track with its samples written in chunks
+func (t *mp4Track) writeSampleTable(chunks []*mp4Chunk, co64 bool) {
+
+	stbl := t.Trak.Find("mdia/minf/stbl")
+	stsd := stbl.Child("stsd")
+	stbl.Children = []*Box{stsd}
+
+	// Durations, run length encoded
+	var stts []byte
+	var entries uint32
+	for i := 0; i < len(t.Samples); {
+		j := i
+		for j < len(t.Samples) && t.Samples[j].Duration == t.Samples[i].Duration {
+			j++
+		}
+		stts = appendUint32(appendUint32(stts, uint32(j-i)), t.Samples[i].Duration)
+		entries++
+		i = j
+	}
+	stbl.Children = append(stbl.Children, newFullBox("stts", 0, 0, append(appendUint32(nil, entries), stts...)))
+
+	// Composition offsets, only when needed
+	var ctts []byte
+	var negative, needed bool
+	entries = 0
+	for i := 0; i < len(t.Samples); {
+		j := i
+		for j < len(t.Samples) && t.Samples[j].CompositionOffset == t.Samples[i].CompositionOffset {
+			j++
+		}
+		offset := t.Samples[i].CompositionOffset
+		needed = needed || offset != 0
+		negative = negative || offset < 0
+		ctts = appendUint32(appendUint32(ctts, uint32(j-i)), uint32(offset))
+		entries++
+		i = j
+	}
+	if needed {
+		version := byte(0)
+		if negative {
+			version = 1
+		}
+		stbl.Children = append(stbl.Children, newFullBox("ctts", version, 0, append(appendUint32(nil, entries), ctts...)))
+	}
+
+	// Sync samples, only when some are not
+	var stss []byte
+	entries = 0
+	for i, sample := range t.Samples {
+		if sample.Sync {
+			stss = appendUint32(stss, uint32(i+1))
+			entries++
+		}
+	}
+	if int(entries) != len(t.Samples) {
+		stbl.Children = append(stbl.Children, newFullBox("stss", 0, 0, append(appendUint32(nil, entries), stss...)))
+	}
+
+	// Sizes
+	stsz := appendUint32(appendUint32(nil, 0), uint32(len(t.Samples)))
+	for _, sample := range t.Samples {
+		stsz = appendUint32(stsz, sample.Size)
+	}
+	stbl.Children = append(stbl.Children, newFullBox("stsz", 0, 0, stsz))
+
+	// Samples per chunk, run length encoded
+	var stsc []byte
+	entries = 0
+	for i, chunk := range chunks {
+		if i == 0 || chunk.Count != chunks[i-1].Count {
+			stsc = appendUint32(appendUint32(appendUint32(stsc, uint32(i+1)), uint32(chunk.Count)), 1)
+			entries++
+		}
+	}
+	stbl.Children = append(stbl.Children, newFullBox("stsc", 0, 0, append(appendUint32(nil, entries), stsc...)))
+
+	// Chunk offsets
+	offsets := appendUint32(nil, uint32(len(chunks)))
+	for _, chunk := range chunks {
+		if co64 {
+			offsets = appendUint64(offsets, chunk.Offset)
+		} else {
+			offsets = appendUint32(offsets, uint32(chunk.Offset))
+		}
+	}
+	if co64 {
+		stbl.Children = append(stbl.Children, newFullBox("co64", 0, 0, offsets))
+	} else {
+		stbl.Children = append(stbl.Children, newFullBox("stco", 0, 0, offsets))
+	}
+}
+
+// Build moov of the progressive file, chunk offsets are relative to mdat data
+func (m *mp4File) buildMoov(chunks []*mp4Chunk, co64 bool) {
+
+	// Fragments are gone
+	m.Moov.Remove("mvex")
+
+	movieTimescale := headerValue(m.Moov.Child("mvhd"), mp4HeaderOffsets["mvhd"].Timescale, false)
+	var movieDuration uint64
+
+	for _, track := range m.Tracks {
+
+		var trackChunks []*mp4Chunk
+		for _, chunk := range chunks {
+			if chunk.Track == track {
+				trackChunks = append(trackChunks, chunk)
+			}
+		}
+		track.writeSampleTable(trackChunks, co64)
+
+		// Fragmented files have no durations
+		duration := track.Duration()
+		setBoxDuration(track.Trak.Find("mdia/mdhd"), duration)
+		if track.Timescale > 0 {
+			duration = duration * movieTimescale / uint64(track.Timescale)
+		}
+		setBoxDuration(track.Trak.Child("tkhd"), duration)
+		if duration > movieDuration {
+			movieDuration = duration
+		}
+	}
+
+	setBoxDuration(m.Moov.Child("mvhd"), movieDuration)
+}
+
+// Write a progressive mp4 file, tracks samples are interleaved by chunks
+func (m *mp4File) Write(f *os.File, ftyp *Box) error {
+
+	chunks := m.chunks()
+
+	// Position of chunks in mdat
+	var mdatSize uint64
+	for _, chunk := range chunks {
+		chunk.Offset = mdatSize
+		for _, sample := range chunk.Track.Samples[chunk.First : chunk.First+chunk.Count] {
+			mdatSize += uint64(sample.Size)
+		}
+	}
+
+	mdatHeader := uint64(8)
+	if mdatSize+8 > math.MaxUint32 {
+		mdatHeader = 16
+	}
+
+	// 64 bits offsets are only used for big files
+	m.buildMoov(chunks, false)
+	co64 := ftyp.Size()+m.Moov.Size()+mdatHeader+mdatSize > math.MaxUint32
+	if co64 {
+		m.buildMoov(chunks, true)
+	}
+
+	// Offsets are now known
+	base := ftyp.Size() + m.Moov.Size() + mdatHeader
+	for _, chunk := range chunks {
+		chunk.Offset += base
+	}
+	m.buildMoov(chunks, co64)
+
+	w := bufio.NewWriter(f)
+	w.Write(ftyp.Bytes())
+	w.Write(m.Moov.Bytes())
+	if mdatHeader == 16 {
+		w.Write(appendUint64(append([]byte{0, 0, 0, 1}, "mdat"...), mdatSize+16))
+	} else {
+		w.Write(append(appendUint32(nil, uint32(mdatSize+8)), "mdat"...))
+	}
+
+	buf := make([]byte, 0, 1<<16)
+	for _, chunk := range chunks {
+		for _, sample := range chunk.Track.Samples[chunk.First : chunk.First+chunk.Count] {
+			data := sample.Data
+			if data == nil {
+				if cap(buf) < int(sample.Size) {
+					buf = make([]byte, sample.Size)
+				}
+				data = buf[:sample.Size]
+				if _, err := m.r.ReadAt(data, sample.Offset); err != nil {
+					return err
+				}
+			}
+			if _, err := w.Write(data); err != nil {
+				return err
+			}
+		}
+	}
+
+	return w.Flush()
+}
+
+// File type of m4a audio files
+func m4aFtyp() *Box {
+	return NewBox("ftyp", []byte("M4A \x00\x00\x02\x00M4A isomiso2mp41"))
+}
+
+// File type of mp4 video files
+func mp4Ftyp() *Box {
+	return NewBox("ftyp", []byte("isom\x00\x00\x02\x00isomiso2avc1mp41"))
+}
+
+// File type matching the tracks, audio only files are m4a
+func (m *mp4File) Ftyp() *Box {
+	for _, track := range m.Tracks {
+		if track.Handler() == "vide" {
+			return mp4Ftyp()
+		}
+	}
+	return m4aFtyp()
+}
+
+// Remux a DASH m4a file into a standalone m4a file
+func RemuxM4A(src string, dest string, meta Metadata) error {
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	stat, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	m, err := openMP4(in, stat.Size())
+	if err != nil {
+		return err
+	}
+	setMP4Tags(m.Moov, meta)
+
+	// m4a files are remuxed in place
+	return replaceFile(dest, in, func(f *os.File) error {
+		return m.Write(f, m4aFtyp())
+	})
+}
+
+// Pack an ISO 639-2 code in 15 bits, as stored in mdhd
+func mp4Language(code string) uint16 {
+	var packed uint16
+	for _, c := range []byte(code[:3]) {
+		packed = packed<<5 | uint16(c-0x60)&0x1f
+	}
+	return packed
+}
+
+// Sample entry of 3GPP timed text, white text centered at the bottom
+func tx3gSampleEntry() *Box {
+	data := []byte{
+		0, 0, 0, 0, 0, 0, 0, 1, // Reserved, data reference index
+		0, 0, 0, 0, // Display flags
+		1, 0xff, // Horizontal and vertical justification
+		0, 0, 0, 0, // Background color
+		0, 0, 0, 0, 0, 0, 0, 0, // Text box
+		0, 0, 0, 0, 0, 1, 0, 0x12, 0xff, 0xff, 0xff, 0xff, // Style record
+	}
+	ftab := NewBox("ftab", append([]byte{0, 1, 0, 1, 5}, "Serif"...))
+	return NewBox("tx3g", append(data, ftab.Bytes()...))
+}
+
+// Timed text samples of cues, gaps are filled with empty samples
+// Timescale is 1000
+func tx3gSamples(cues []Cue) []mp4Sample {
+
+	var samples []mp4Sample
+	addSample := func(text string, duration int64) {
+		data := append([]byte{byte(len(text) >> 8), byte(len(text))}, text...)
+		samples = append(samples, mp4Sample{Data: data, Size: uint32(len(data)), Duration: uint32(duration), Sync: true})
+	}
+
+	var position int64
+	for i, cue := range cues {
+		start, end := int64(cue.Start/time.Millisecond), int64(cue.End/time.Millisecond)
+		if i+1 < len(cues) && int64(cues[i+1].Start/time.Millisecond) < end {
+			end = int64(cues[i+1].Start / time.Millisecond)
+		}
+		if start < position {
+			start = position
+		}
+		if end <= start {
+			continue
+		}
+		if start > position {
+			addSample("", start-position)
+		}
+		addSample(cue.Text, end-start)
+		position = end
+	}
+
+	return samples
+}
+
+// Handler type of a track
+func (t *mp4Track) Handler() string {
+	if hdlr := t.Trak.Find("mdia/hdlr"); hdlr != nil && len(hdlr.Data) >= 12 {
+		return string(hdlr.Data[8:12])
+	}
+	return ""
+}
+
+// Add a track of 3GPP timed text samples, used by subtitles and chapters
+func (m *mp4File) addTextTrack(handler string, lang string, name string, samples []mp4Sample) *mp4Track {
+
+	var id uint32
+	var width, height []byte
+	for _, track := range m.Tracks {
+		if track.Id > id {
+			id = track.Id
+		}
+		// Text box covers the video
+		if tkhd := track.Trak.Child("tkhd"); tkhd != nil && len(tkhd.Data) >= 8 && width == nil {
+			size := tkhd.Data[len(tkhd.Data)-8:]
+			if binary.BigEndian.Uint64(size) != 0 {
+				width, height = size[:4], size[4:]
+			}
+		}
+	}
+	id++
+
+	// Subtitles are enabled and share an alternate group, chapters are only in movie
+	flags := uint32(2)
+	tkhd := make([]byte, 80)
+	binary.BigEndian.PutUint32(tkhd[8:], id)
+	if handler == "sbtl" {
+		flags = 3
+		binary.BigEndian.PutUint16(tkhd[30:], 2)
+	}
+	for i, v := range []uint32{0x10000, 0, 0, 0, 0x10000, 0, 0, 0, 0x40000000} {
+		binary.BigEndian.PutUint32(tkhd[36+i*4:], v)
+	}
+	copy(tkhd[72:], width)
+	copy(tkhd[76:], height)
+
+	mdhd := make([]byte, 20)
+	binary.BigEndian.PutUint32(mdhd[8:], 1000)
+	binary.BigEndian.PutUint16(mdhd[16:], mp4Language(iso639Code(lang, false)))
+
+	hdlr := append(make([]byte, 4), handler...)
+	hdlr = append(append(hdlr, make([]byte, 12)...), name+"\x00"...)
+
+	dref := newFullBox("dref", 0, 0, append(appendUint32(nil, 1), newFullBox("url ", 0, 1, nil).Bytes()...))
+	stsd := newFullBox("stsd", 0, 0, append(appendUint32(nil, 1), tx3gSampleEntry().Bytes()...))
+
+	trak := NewContainer("trak",
+		newFullBox("tkhd", 0, flags, tkhd),
+		NewContainer("mdia",
+			newFullBox("mdhd", 0, 0, mdhd),
+			newFullBox("hdlr", 0, 0, hdlr),
+			NewContainer("minf",
+				newFullBox("nmhd", 0, 0, nil),
+				NewContainer("dinf", dref),
+				NewContainer("stbl", stsd))))
+
+	track := &mp4Track{Id: id, Timescale: 1000, Trak: trak, Samples: samples}
+	m.Moov.Children = append(m.Moov.Children, trak)
+	m.Tracks = append(m.Tracks, track)
+
+	// Next track id ends mvhd
+	if mvhd := m.Moov.Child("mvhd"); mvhd != nil && len(mvhd.Data) >= 4 {
+		binary.BigEndian.PutUint32(mvhd.Data[len(mvhd.Data)-4:], id+1)
+	}
+
+	return track
+}
+
+// Add a tx3g (mov_text) subtitle track
+func (m *mp4File) AddSubtitleTrack(lang string, name string, cues []Cue) {
+	if samples := tx3gSamples(cues); len(samples) > 0 {
+		m.addTextTrack("sbtl", lang, name, samples)
+	}
+}
+
+// Add a QuickTime chapter track, referenced by audio and video tracks
+func (m *mp4File) AddChapterTrack(chapters []Chapter) {
+
+	// Open chapters last until the end of the file
+	var cues []Cue
+	for _, chapter := range chapters {
+		end := chapter.End()
+		if chapter.EndTime <= chapter.StartTime {
+			end = m.Duration()
+		}
+		if end > chapter.Start() {
+			cues = append(cues, Cue{Start: chapter.Start(), End: end, Text: chapter.Title})
+		}
+	}
+	samples := tx3gSamples(cues)
+	if len(samples) == 0 {
+		return
+	}
+
+	chapterTrack := m.addTextTrack("text", "und", "Chapters", samples)
+	chap := NewBox("chap", appendUint32(nil, chapterTrack.Id)).Bytes()
+	for _, track := range m.Tracks {
+		if handler := track.Handler(); handler != "vide" && handler != "soun" {
+			continue
+		}
+		tref := track.Trak.Child("tref")
+		if tref == nil {
+			tref = NewBox("tref", nil)
+			track.Trak.Children = append(track.Trak.Children, tref)
+		}
+		tref.Data = append(tref.Data, chap...)
+	}
+}
+
+// Open a mp4 file, call fn with it and close it
+func withMP4(filename string, fn func(m *mp4File) error) error {
+
+	in, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	stat, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	m, err := openMP4(in, stat.Size())
+	if err != nil {
+		return err
+	}
+
+	return fn(m)
+}
+
+// Embed subtitles and chapters as text tracks in a mp4 file
+// Tags are updated in the same pass
+func EmbedMP4Tracks(filename string, subtitles []SubtitleTrack, chapters []Chapter, meta *Metadata) error {
+	return withMP4(filename, func(m *mp4File) error {
+
+		for _, subtitle := range subtitles {
+			m.AddSubtitleTrack(subtitle.Lang, subtitle.Name, subtitle.Cues)
+		}
+		if len(chapters) > 0 {
+			m.AddChapterTrack(chapters)
+		}
+		if meta != nil {
+			setMP4Tags(m.Moov, *meta)
+		}
+
+		return replaceFile(filename, m.r, func(f *os.File) error {
+			return m.Write(f, m.Ftyp())
+		})
+	})
+}
+
+// Decode times of the sync samples of the main track, in seconds
+// Video track when there is one, samples of other tracks are all sync
+func (m *mp4File) syncTimes() []float64 {
+
+	var main *mp4Track
+	for _, track := range m.Tracks {
+		if handler := track.Handler(); handler == "vide" || (handler == "soun" && main == nil) {
+			main = track
+		}
+	}
+	if main == nil || main.Timescale == 0 {
+		return []float64{0}
+	}
+
+	var times []float64
+	var decodeTime uint64
+	for _, sample := range main.Samples {
+		if sample.Sync {
+			times = append(times, float64(decodeTime)/float64(main.Timescale))
+		}
+		decodeTime += uint64(sample.Duration)
+	}
+
+	return times
+}
+
+// Copy of the file with samples decoded between two times, in seconds
+func (m *mp4File) Cut(from float64, to float64) (*mp4File, error) {
+
+	boxes, err := ParseBoxes(m.Moov.Bytes(), "")
+	if err != nil {
+		return nil, err
+	}
+	part := &mp4File{r: m.r, Moov: boxes[0]}
+
+	for _, trak := range part.Moov.FindAll("trak") {
+
+		// Edit lists would apply to the whole file
+		trak.Remove("edts")
+
+		source := m.Track(uint32(headerValue(trak.Child("tkhd"), mp4HeaderOffsets["tkhd"].TrackId, false)))
+		if source == nil || source.Timescale == 0 {
+			continue
+		}
+		track := &mp4Track{Id: source.Id, Timescale: source.Timescale, Trak: trak}
+
+		var decodeTime uint64
+		for _, sample := range source.Samples {
+			t := float64(decodeTime) / float64(source.Timescale)
+			if t >= from && t < to {
+				track.Samples = append(track.Samples, sample)
+			}
+			decodeTime += uint64(sample.Duration)
+		}
+		part.Tracks = append(part.Tracks, track)
+	}
+
+	return part, nil
+}
+
+// Split a mp4 file in chapters written to files
+// Chapters are cut on the sync sample preceding their start
+func SplitMP4(filename string, chapters []Chapter, files []string) error {
+	return withMP4(filename, func(m *mp4File) error {
+
+		syncTimes := m.syncTimes()
+		cuts := make([]float64, len(chapters)+1)
+		for i, chapter := range chapters {
+			cuts[i] = cutTime(syncTimes, chapter.StartTime)
+		}
+		cuts[len(chapters)] = math.Inf(1)
+
+		ftyp := m.Ftyp()
+		for i := range chapters {
+			part, err := m.Cut(cuts[i], cuts[i+1])
+			if err != nil {
+				return err
+			}
+			err = writeFileAtomic(files[i], func(f *os.File) error {
+				return part.Write(f, ftyp)
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Last cut point at or before the given time
+func cutTime(points []float64, t float64) float64 {
+	cut := 0.0
+	for _, point := range points {
+		if point > t {
+			break
+		}
+		cut = point
+	}
+	return cut
+}
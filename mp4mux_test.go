@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Build a fragmented m4a with one fragment of three samples
+func buildTestFragmentedMP4() []byte {
+
+	mvhd := newFullBox("mvhd", 0, 0, make([]byte, 96))
+	copy(mvhd.Data[12:], appendUint32(nil, 1000))
+	tkhd := newFullBox("tkhd", 0, 0, make([]byte, 80))
+	copy(tkhd.Data[12:], appendUint32(nil, 1))
+	mdhd := newFullBox("mdhd", 0, 0, make([]byte, 20))
+	copy(mdhd.Data[12:], appendUint32(nil, 44100))
+
+	empty := appendUint32(nil, 0)
+	stbl := NewContainer("stbl",
+		newFullBox("stsd", 0, 0, empty),
+		newFullBox("stts", 0, 0, empty),
+		newFullBox("stsc", 0, 0, empty),
+		newFullBox("stsz", 0, 0, append(appendUint32(nil, 0), empty...)),
+		newFullBox("stco", 0, 0, empty))
+	hdlr := newFullBox("hdlr", 0, 0, append(append(make([]byte, 4), "soun"...), make([]byte, 13)...))
+	trak := NewContainer("trak", tkhd, NewContainer("mdia", mdhd, hdlr, NewContainer("minf", stbl)))
+
+	// Track 1, sample description 1, duration 1024, size 0, flags 0
+	trex := newFullBox("trex", 0, 0, []byte{0, 0, 0, 1, 0, 0, 0, 1, 0, 0, 4, 0, 0, 0, 0, 0, 0, 0, 0, 0})
+	moov := NewContainer("moov", mvhd, trak, NewContainer("mvex", trex))
+
+	// Data offset and sizes of samples
+	trun := newFullBox("trun", 0, 0x201, nil)
+	trun.Data = appendUint32(trun.Data, 3)
+	trun.Data = appendUint32(trun.Data, 0)
+	for _, size := range []uint32{3, 4, 5} {
+		trun.Data = appendUint32(trun.Data, size)
+	}
+	moof := NewContainer("moof", NewContainer("traf", newFullBox("tfhd", 0, 0x20000, appendUint32(nil, 1)), trun))
+	copy(trun.Data[8:], appendUint32(nil, uint32(moof.Size()+8)))
+
+	var buf bytes.Buffer
+	buf.Write(m4aFtyp().Bytes())
+	buf.Write(moov.Bytes())
+	buf.Write(moof.Bytes())
+	buf.Write(NewBox("mdat", []byte("aaabbbbccccc")).Bytes())
+	return buf.Bytes()
+}
+
+func TestRemuxM4A(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gotubedl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "audio.m4a")
+	if err := ioutil.WriteFile(filename, buildTestFragmentedMP4(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RemuxM4A(filename, filename, Metadata{Title: "Never Gonna Give You Up"}); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	stat, _ := f.Stat()
+
+	m, err := openMP4(f, stat.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Samples are read from the sample table now
+	if m.Moov.Child("mvex") != nil {
+		t.Errorf("file still fragmented")
+	}
+	track := m.Tracks[0]
+	if len(track.Samples) != 3 {
+		t.Fatalf("expected 3 samples, got %d", len(track.Samples))
+	}
+	var data []byte
+	for _, sample := range track.Samples {
+		raw := make([]byte, sample.Size)
+		f.ReadAt(raw, sample.Offset)
+		data = append(data, raw...)
+	}
+	if string(data) != "aaabbbbccccc" {
+		t.Errorf("bad samples data %q", data)
+	}
+
+	// Durations are known
+	if duration := boxDuration(track.Trak.Find("mdia/mdhd")); duration != 3072 {
+		t.Errorf("bad duration %d", duration)
+	}
+}
+
+func TestEmbedMP4Subtitles(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gotubedl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "video.mp4")
+	if err := ioutil.WriteFile(filename, buildTestFragmentedMP4(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	subtitles := []SubtitleTrack{{
+		Subtitle: Subtitle{Lang: "en", Name: "English"},
+		Cues:     []Cue{{Start: time.Second, End: 2 * time.Second, Text: "Hello"}, {Start: 3 * time.Second, End: 4 * time.Second, Text: "World"}},
+	}}
+	if err := EmbedMP4Tracks(filename, subtitles, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	stat, _ := f.Stat()
+
+	m, err := openMP4(f, stat.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Tracks) != 2 {
+		t.Fatalf("expected 2 tracks, got %d", len(m.Tracks))
+	}
+
+	track := m.Tracks[1]
+	if track.Id != 2 || track.Timescale != 1000 {
+		t.Errorf("bad subtitle track %d, timescale %d", track.Id, track.Timescale)
+	}
+	if hdlr := track.Trak.Find("mdia/hdlr"); hdlr == nil || string(hdlr.Data[8:12]) != "sbtl" {
+		t.Errorf("bad handler")
+	}
+	if mdhd := track.Trak.Find("mdia/mdhd"); mdhd.Data[20] != byte(mp4Language("eng")>>8) || mdhd.Data[21] != byte(mp4Language("eng")) {
+		t.Errorf("bad language")
+	}
+
+	// Gaps are empty samples
+	var texts []string
+	for _, sample := range track.Samples {
+		raw := make([]byte, sample.Size)
+		f.ReadAt(raw, sample.Offset)
+		texts = append(texts, string(raw[2:]))
+		if sample.Duration != 1000 {
+			t.Errorf("bad duration %d", sample.Duration)
+		}
+	}
+	if strings.Join(texts, "|") != "|Hello||World" {
+		t.Errorf("bad samples %q", texts)
+	}
+}
+
+func TestSplitMP4(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gotubedl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "audio.m4a")
+	if err := ioutil.WriteFile(filename, buildTestFragmentedMP4(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Samples last 1024 / 44100 seconds, second chapter is cut on the second sample
+	chapters := []Chapter{{Title: "One", StartTime: 0, EndTime: 0.03}, {Title: "Two", StartTime: 0.03, EndTime: 0.07}}
+	if err := EmbedMP4Tracks(filename, nil, chapters, nil); err != nil {
+		t.Fatal(err)
+	}
+	files := []string{filepath.Join(dir, "one.m4a"), filepath.Join(dir, "two.m4a")}
+	if err := SplitMP4(filename, chapters, files); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, expected := range []string{"aaa", "bbbbccccc"} {
+
+		f, err := os.Open(files[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		stat, _ := f.Stat()
+
+		m, err := openMP4(f, stat.Size())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(m.Tracks) != 2 || m.Tracks[1].Handler() != "text" {
+			t.Fatalf("chapter track missing")
+		}
+		if tref := m.Tracks[0].Trak.Child("tref"); tref == nil || string(tref.Data[4:8]) != "chap" {
+			t.Errorf("chapter track not referenced")
+		}
+
+		var data []byte
+		for _, sample := range m.Tracks[0].Samples {
+			raw := make([]byte, sample.Size)
+			f.ReadAt(raw, sample.Offset)
+			data = append(data, raw...)
+		}
+		if string(data) != expected {
+			t.Errorf("part %d: expected %q, got %q", i+1, expected, data)
+		}
+	}
+}
+
+func TestSelectAudioFormat(t *testing.T) {
+
+	formats := Formats{}
+	for _, id := range []string{"18", "140", "171", "251"} {
+		formats[id] = BaseFormats[id]
+	}
+
+	if format, _ := SelectAudioFormat(formats, "best"); format.Acodec != "opus" {
+		t.Errorf("bad best audio format %s", format.Acodec)
+	}
+	if format, _ := SelectAudioFormat(formats, "m4a"); format.Acodec != "aac" || format.Vcodec != "" {
+		t.Errorf("bad m4a audio format")
+	}
+	if _, err := SelectAudioFormat(Formats{"18": BaseFormats["18"]}, "best"); err == nil {
+		t.Errorf("no audio only format expected")
+	}
+}
+
+func TestEmbedM4AChapters(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gotubedl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "audio.m4a")
+	if err := ioutil.WriteFile(filename, buildTestFragmentedMP4(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Last chapter is open and ends with the file, after 3072 samples at 44100Hz
+	chapters := []Chapter{{Title: "Intro", StartTime: 0, EndTime: 0.03}, {Title: "Song", StartTime: 0.03}}
+	if err := embedTracks(filename, "m4a", nil, chapters, &Metadata{Title: "Never Gonna Give You Up"}); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	stat, _ := f.Stat()
+
+	m, err := openMP4(f, stat.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Tracks) != 2 || m.Tracks[1].Handler() != "text" || len(m.Tracks[1].Samples) != 2 {
+		t.Fatalf("expected a chapter track with 2 samples")
+	}
+	ftyp := make([]byte, 12)
+	f.ReadAt(ftyp, 0)
+	if string(ftyp[8:]) != "M4A " {
+		t.Errorf("expected m4a file type, got %q", ftyp[8:])
+	}
+	if duration := m.Tracks[1].Samples[1].Duration; duration != 39 {
+		t.Errorf("expected open chapter to last until the end, got %d", duration)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/xml"
 	"fmt"
 	"regexp"
@@ -40,7 +41,7 @@ type BaseURL struct {
 	Value         string `xml:",chardata"`
 }
 
-func ParseMPDManifest(mpdUrl string, video *Video) error {
+func ParseMPDManifest(ctx context.Context, mpdUrl string, video *Video) error {
 
 	// Decrypt signature
 	regSig := regexp.MustCompile(`/s/([a-fA-F0-9\.]+)`)
@@ -51,7 +52,7 @@ func ParseMPDManifest(mpdUrl string, video *Video) error {
 	}
 
 	// Get manifest
-	mpdContent, err := downloadPage(mpdUrl)
+	mpdContent, err := downloadPage(ctx, mpdUrl)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client used by every request, pages and media alike
+var httpClient = http.DefaultClient
+
+// Headers added to every request
+var httpHeaders = http.Header{}
+
+// Parse a proxy url, scheme defaults to http
+func parseProxy(proxy string) (*url.URL, error) {
+
+	if !strings.Contains(proxy, "://") {
+		proxy = "http://" + proxy
+	}
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %s", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy %s", proxy)
+	}
+
+	return u, nil
+}
+
+// Parse headers given as name:value
+func parseHeaders(headers []string) (http.Header, error) {
+	result := http.Header{}
+	for _, header := range headers {
+		parts := strings.SplitN(header, ":", 2)
+		name := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || name == "" {
+			return nil, fmt.Errorf("invalid header %s, expected name:value", header)
+		}
+		result.Add(name, strings.TrimSpace(parts[1]))
+	}
+	return result, nil
+}
+
+// Build a client from the network options
+// Timeouts apply to connections, TLS handshakes and waiting for response headers,
+// bodies may take as long as they need
+func newHTTPClient() (*http.Client, error) {
+
+	timeout := time.Duration(opts.SocketTimeout) * time.Second
+	dialer := &net.Dialer{
+		Timeout:   timeout,
+		KeepAlive: 30 * time.Second,
+	}
+
+	// Only addresses of the source address family are dialed
+	if opts.SourceAddress != "" {
+		ip := net.ParseIP(opts.SourceAddress)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid source address %s", opts.SourceAddress)
+		}
+		dialer.LocalAddr = &net.TCPAddr{IP: ip}
+	}
+
+	network := "tcp"
+	switch {
+	case opts.ForceIPv4 && opts.ForceIPv6:
+		return nil, fmt.Errorf("can't force both IPv4 and IPv6")
+	case opts.ForceIPv4:
+		network = "tcp4"
+	case opts.ForceIPv6:
+		network = "tcp6"
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if opts.Proxy != "" {
+		proxyUrl, err := parseProxy(opts.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		proxy = http.ProxyURL(proxyUrl)
+	}
+
+	transport := &http.Transport{
+		Proxy: proxy,
+		DialContext: func(ctx context.Context, _, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr)
+		},
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   timeout,
+		ResponseHeaderTimeout: timeout,
+		ExpectContinueTimeout: time.Second,
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
+
+// Create a GET request with the custom headers
+func newRequest(url string) (*http.Request, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	addHeaders(req)
+	return req, nil
+}
+
+func addHeaders(req *http.Request) {
+	for name, values := range httpHeaders {
+		req.Header[name] = append([]string(nil), values...)
+	}
+}
+
+// Setup client and headers from program options
+func setupNetwork() error {
+
+	client, err := newHTTPClient()
+	if err != nil {
+		return err
+	}
+
+	headers, err := parseHeaders(opts.AddHeaders)
+	if err != nil {
+		return err
+	}
+	if opts.UserAgent != "" {
+		headers.Set("User-Agent", opts.UserAgent)
+	}
+
+	// Cookies are sent with every request
+	if opts.Cookies != "" {
+		if cookieJar, err = loadCookieFile(opts.Cookies); err != nil {
+			return err
+		}
+		client.Jar = cookieJar
+	}
+
+	httpClient, httpHeaders = client, headers
+	return nil
+}
@@ -0,0 +1,545 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"math/bits"
+	"os"
+)
+
+// Pages are flushed once they hold this much data
+const oggPageSize = 4096
+
+// Ogg CRC, polynomial 0x04c11db7 without reflection
+var oggCRCTable = func() (table [256]uint32) {
+	for i := range table {
+		crc := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return
+}()
+
+func oggCRC(data []byte) uint32 {
+	var crc uint32
+	for _, b := range data {
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^b]
+	}
+	return crc
+}
+
+// Write packets of a single logical stream into ogg pages
+type OggWriter struct {
+	w         io.Writer
+	serial    uint32
+	sequence  uint32
+	granule   int64 // Granule of the last packet completed in page
+	completed bool  // A packet ends in the current page
+	continued bool  // Current page starts with the end of a packet
+	segments  []byte
+	data      []byte
+}
+
+func NewOggWriter(w io.Writer, serial uint32) *OggWriter {
+	return &OggWriter{w: w, serial: serial}
+}
+
+// Add a packet ending at the given granule position
+func (o *OggWriter) WritePacket(packet []byte, granule int64) error {
+
+	for i := 0; ; {
+
+		// Packets are split in segments of 255 bytes, a shorter one ends the packet
+		size := len(packet) - i
+		if size > 255 {
+			size = 255
+		}
+		o.segments = append(o.segments, byte(size))
+		o.data = append(o.data, packet[i:i+size]...)
+		i += size
+
+		done := size < 255
+		if done {
+			o.granule = granule
+			o.completed = true
+		}
+
+		if len(o.segments) == 255 {
+			if err := o.writePage(false); err != nil {
+				return err
+			}
+			o.continued = !done
+		}
+
+		if done {
+			break
+		}
+	}
+
+	if len(o.data) >= oggPageSize {
+		return o.Flush()
+	}
+
+	return nil
+}
+
+// Write pending packets in a page
+func (o *OggWriter) Flush() error {
+	if len(o.segments) == 0 {
+		return nil
+	}
+	return o.writePage(false)
+}
+
+// Write last page of the stream
+func (o *OggWriter) Close() error {
+	return o.writePage(true)
+}
+
+func (o *OggWriter) writePage(last bool) error {
+
+	var headerType byte
+	if o.continued {
+		headerType |= 0x01
+	}
+	if o.sequence == 0 {
+		headerType |= 0x02
+	}
+	if last {
+		headerType |= 0x04
+	}
+
+	// No packet ends in this page, except for an empty last page
+	granule := int64(-1)
+	if o.completed || (last && len(o.segments) == 0) {
+		granule = o.granule
+	}
+
+	page := make([]byte, 27, 27+len(o.segments)+len(o.data))
+	copy(page, "OggS")
+	page[5] = headerType
+	binary.LittleEndian.PutUint64(page[6:], uint64(granule))
+	binary.LittleEndian.PutUint32(page[14:], o.serial)
+	binary.LittleEndian.PutUint32(page[18:], o.sequence)
+	page[26] = byte(len(o.segments))
+	page = append(page, o.segments...)
+	page = append(page, o.data...)
+	binary.LittleEndian.PutUint32(page[22:], oggCRC(page))
+
+	o.sequence++
+	o.completed = false
+	o.continued = false
+	o.segments = o.segments[:0]
+	o.data = o.data[:0]
+
+	_, err := o.w.Write(page)
+	return err
+}
+
+// Vorbis comments describing the metadata
+func vorbisComments(meta Metadata) []string {
+
+	var comments []string
+	addComment := func(name string, value string) {
+		if value != "" {
+			comments = append(comments, name+"="+value)
+		}
+	}
+
+	addComment("TITLE", meta.Title)
+	addComment("ARTIST", meta.Artist)
+	addComment("DATE", meta.Date)
+	addComment("DESCRIPTION", meta.Description)
+	addComment("PURL", meta.Url)
+	addComment("YOUTUBE_ID", meta.VideoId)
+
+	// Cover art is stored as a base64 flac picture block
+	if len(meta.Cover) > 0 {
+		addComment("METADATA_BLOCK_PICTURE", base64.StdEncoding.EncodeToString(flacPicture(meta)))
+	}
+
+	return comments
+}
+
+// Serialize vorbis comments, as used by OpusTags and vorbis comment header
+func encodeVorbisComments(comments []string) []byte {
+	vendor := "gotubedl"
+	buf := make([]byte, 0, 1024)
+	buf = appendUint32LE(buf, uint32(len(vendor)))
+	buf = append(buf, vendor...)
+	buf = appendUint32LE(buf, uint32(len(comments)))
+	for _, comment := range comments {
+		buf = appendUint32LE(buf, uint32(len(comment)))
+		buf = append(buf, comment...)
+	}
+	return buf
+}
+
+func appendUint32LE(buf []byte, v uint32) []byte {
+	return append(buf, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+// FLAC picture block holding the front cover
+func flacPicture(meta Metadata) []byte {
+
+	config, _, _ := image.DecodeConfig(bytes.NewReader(meta.Cover))
+
+	buf := appendUint32(nil, 3) // Front cover
+	buf = appendUint32(buf, uint32(len(meta.CoverMime)))
+	buf = append(buf, meta.CoverMime...)
+	buf = appendUint32(buf, 0) // No description
+	buf = appendUint32(buf, uint32(config.Width))
+	buf = appendUint32(buf, uint32(config.Height))
+	buf = appendUint32(buf, 24) // Color depth
+	buf = appendUint32(buf, 0)  // Not indexed
+	buf = appendUint32(buf, uint32(len(meta.Cover)))
+	return append(buf, meta.Cover...)
+}
+
+// Number of samples at 48kHz in an opus packet, read from its TOC
+func opusPacketSamples(packet []byte) int64 {
+
+	if len(packet) == 0 {
+		return 0
+	}
+
+	config := packet[0] >> 3
+	var frameSamples int64
+	switch {
+	case config < 12: // SILK, 10 to 60ms
+		frameSamples = []int64{480, 960, 1920, 2880}[config%4]
+	case config < 16: // Hybrid, 10 or 20ms
+		frameSamples = []int64{480, 960}[config%2]
+	default: // CELT, 2.5 to 20ms
+		frameSamples = []int64{120, 240, 480, 960}[config%4]
+	}
+
+	switch packet[0] & 3 {
+	case 0:
+		return frameSamples
+	case 1, 2:
+		return 2 * frameSamples
+	}
+	if len(packet) < 2 {
+		return 0
+	}
+	return int64(packet[1]&0x3f) * frameSamples
+}
+
+// Find the first track with the given codec
+func findTrack(m *matroskaFile, codecId string) *Element {
+	for _, track := range m.Tracks() {
+		if track.ChildString(mkvCodecId) == codecId {
+			return track
+		}
+	}
+	return nil
+}
+
+// Build OpusHead from the track when the codec private data is missing
+func opusHead(track *Element) []byte {
+
+	if private := track.Child(mkvCodecPrivate); private != nil && bytes.HasPrefix(private.Data, []byte("OpusHead")) {
+		return private.Data
+	}
+
+	channels := uint64(2)
+	sampleRate := uint32(48000)
+	if audio := track.Child(mkvAudio); audio != nil {
+		channels = audio.ChildUint(mkvChannels, 2)
+		if frequency := audio.Child(mkvSamplingFrequency); frequency != nil {
+			sampleRate = uint32(frequency.Float())
+		}
+	}
+
+	// Codec delay is in nanoseconds
+	preSkip := track.ChildUint(mkvCodecDelay, 0) * 48000 / 1000000000
+
+	head := []byte("OpusHead")
+	head = append(head, 1, byte(channels), byte(preSkip), byte(preSkip>>8))
+	head = appendUint32LE(head, sampleRate)
+	return append(head, 0, 0, 0) // No gain, mapping family 0
+}
+
+// Codec specific parts of an ogg stream
+type oggCodec interface {
+	// Header packets, the first one has its own page
+	Headers(meta Metadata) [][]byte
+	// Number of samples decoded from a packet
+	Samples(packet []byte) int64
+}
+
+type opusCodec struct {
+	track *Element
+}
+
+func (c *opusCodec) Headers(meta Metadata) [][]byte {
+	tags := append([]byte("OpusTags"), encodeVorbisComments(vorbisComments(meta))...)
+	return [][]byte{opusHead(c.track), tags}
+}
+
+func (c *opusCodec) Samples(packet []byte) int64 {
+	return opusPacketSamples(packet)
+}
+
+type vorbisCodec struct {
+	headers    [][]byte // Identification, comment and setup headers
+	blocksizes [2]int64
+	modeFlags  []bool // Long block flag of each mode
+	modeBits   uint
+	previous   int64 // Size of the previous block
+}
+
+// Split xiph laced headers of codec private data
+func xiphHeaders(data []byte) ([][]byte, error) {
+
+	if len(data) == 0 {
+		return nil, fmt.Errorf("no codec private data")
+	}
+	count := int(data[0]) + 1
+	data = data[1:]
+
+	var sizes []int
+	for i := 0; i < count-1; i++ {
+		size, n, err := readXiphSize(data)
+		if err != nil {
+			return nil, err
+		}
+		sizes = append(sizes, size)
+		data = data[n:]
+	}
+
+	var headers [][]byte
+	for _, size := range sizes {
+		if size > len(data) {
+			return nil, fmt.Errorf("invalid codec private data")
+		}
+		headers = append(headers, data[:size])
+		data = data[size:]
+	}
+
+	return append(headers, data), nil
+}
+
+// Read bits of a vorbis packet backwards, starting from the last one
+// Vorbis packs bits from the least significant, so values are read as is
+type reverseBitReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *reverseBitReader) read(n int) (uint32, bool) {
+	var value uint32
+	for i := 0; i < n; i++ {
+		if r.pos < 0 {
+			return 0, false
+		}
+		value = value<<1 | uint32(r.data[r.pos/8]>>uint(r.pos%8))&1
+		r.pos--
+	}
+	return value, true
+}
+
+// Read block flags of vorbis modes from the end of the setup header
+// Modes are the last thing in the header, but the number of modes comes before them
+// so every count is tried until the count field matches, like ffmpeg does
+func vorbisModeFlags(setup []byte) ([]bool, error) {
+
+	r := &reverseBitReader{data: setup, pos: len(setup)*8 - 1}
+
+	// Skip padding up to the framing bit
+	for {
+		bit, ok := r.read(1)
+		if !ok {
+			return nil, fmt.Errorf("invalid vorbis setup header")
+		}
+		if bit == 1 {
+			break
+		}
+	}
+	framing := r.pos
+
+	// A mode is blockflag, windowtype, transformtype and mapping, 41 bits
+	modeCount := 0
+	for count := 1; count <= 64; count++ {
+		mapping, _ := r.read(8)
+		transform, _ := r.read(16)
+		window, ok := r.read(16)
+		if !ok || mapping > 63 || transform != 0 || window != 0 {
+			break
+		}
+		r.read(1)
+		pos := r.pos
+		if n, ok := r.read(6); ok && int(n)+1 == count {
+			modeCount = count
+		}
+		r.pos = pos
+	}
+	if modeCount == 0 {
+		return nil, fmt.Errorf("no vorbis mode found")
+	}
+
+	flags := make([]bool, modeCount)
+	r.pos = framing
+	for i := modeCount - 1; i >= 0; i-- {
+		r.read(40)
+		flag, _ := r.read(1)
+		flags[i] = flag == 1
+	}
+
+	return flags, nil
+}
+
+func newVorbisCodec(track *Element) (*vorbisCodec, error) {
+
+	private := track.Child(mkvCodecPrivate)
+	if private == nil {
+		return nil, fmt.Errorf("no vorbis headers")
+	}
+	headers, err := xiphHeaders(private.Data)
+	if err != nil {
+		return nil, err
+	}
+	if len(headers) != 3 || len(headers[0]) < 30 {
+		return nil, fmt.Errorf("invalid vorbis headers")
+	}
+
+	c := &vorbisCodec{headers: headers}
+
+	// Short and long block sizes
+	c.blocksizes[0] = 1 << (headers[0][28] & 0xf)
+	c.blocksizes[1] = 1 << (headers[0][28] >> 4)
+
+	if c.modeFlags, err = vorbisModeFlags(headers[2]); err != nil {
+		return nil, err
+	}
+	c.modeBits = uint(bits.Len(uint(len(c.modeFlags) - 1)))
+
+	return c, nil
+}
+
+func (c *vorbisCodec) Headers(meta Metadata) [][]byte {
+	comments := append([]byte("\x03vorbis"), encodeVorbisComments(vorbisComments(meta))...)
+	return [][]byte{c.headers[0], append(comments, 1), c.headers[2]}
+}
+
+// Samples are the overlap of the previous and the current block
+func (c *vorbisCodec) Samples(packet []byte) int64 {
+
+	if len(packet) == 0 || packet[0]&1 != 0 {
+		return 0
+	}
+
+	mode := int(packet[0]>>1) & (1<<c.modeBits - 1)
+	if mode >= len(c.modeFlags) {
+		return 0
+	}
+
+	size := c.blocksizes[0]
+	if c.modeFlags[mode] {
+		size = c.blocksizes[1]
+	}
+
+	var samples int64
+	if c.previous != 0 {
+		samples = (c.previous + size) / 4
+	}
+	c.previous = size
+
+	return samples
+}
+
+// Extract the audio track of a webm file into an ogg file
+func extractOgg(src string, dest string, codecId string, meta Metadata, newCodec func(track *Element) (oggCodec, error)) error {
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	stat, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	m, err := openMatroska(in, stat.Size())
+	if err != nil {
+		return err
+	}
+
+	track := findTrack(m, codecId)
+	if track == nil {
+		return fmt.Errorf("no %s track in %s", codecId, src)
+	}
+	trackNumber := track.ChildUint(mkvTrackNumber, 1)
+
+	codec, err := newCodec(track)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(dest, func(out *os.File) error {
+
+		ogg := NewOggWriter(out, uint32(trackNumber))
+
+		// Identification header has its own page, audio starts on a new page
+		for i, header := range codec.Headers(meta) {
+			if err := ogg.WritePacket(header, 0); err != nil {
+				return err
+			}
+			if i == 0 {
+				if err := ogg.Flush(); err != nil {
+					return err
+				}
+			}
+		}
+		if err := ogg.Flush(); err != nil {
+			return err
+		}
+
+		// Granule is the number of samples decoded so far
+		var granule int64
+		err := m.ReadBlocks(func(block Block) error {
+			if block.Track != trackNumber {
+				return nil
+			}
+			for _, frame := range block.Frames {
+				granule += codec.Samples(frame)
+				if err := ogg.WritePacket(frame, granule); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		return ogg.Close()
+	})
+}
+
+// Extract opus audio of a webm file into an ogg opus file
+func ExtractOpus(src string, dest string, meta Metadata) error {
+	return extractOgg(src, dest, "A_OPUS", meta, func(track *Element) (oggCodec, error) {
+		return &opusCodec{track: track}, nil
+	})
+}
+
+// Extract vorbis audio of a webm file into an ogg vorbis file
+func ExtractVorbis(src string, dest string, meta Metadata) error {
+	return extractOgg(src, dest, "A_VORBIS", meta, func(track *Element) (oggCodec, error) {
+		return newVorbisCodec(track)
+	})
+}
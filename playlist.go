@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Match the playlist id of a youtube url
+var regPlaylistId = regexp.MustCompile(`youtube\.com/.*[?&]list=([0-9A-Za-z_-]+)`)
+
+// Extract playlist id from youtube's url, empty when there is none
+func ExtractPlaylistId(playlistUrl string) string {
+	match := regPlaylistId.FindStringSubmatch(playlistUrl)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+// Match an item of --playlist-items: N, N-M, N- or -M
+var regPlaylistItem = regexp.MustCompile(`^(\d*)(-?)(\d*)$`)
+
+// Indices of items to download, starting at 1, in playlist order
+// Items like "1-3,7,10-" take precedence over start and end, an end of 0 is the last item
+// Out of range items are ignored, ranges may go backward
+func selectPlaylistItems(spec string, start int, end int, count int) ([]int, error) {
+
+	if spec == "" {
+		if start < 1 {
+			start = 1
+		}
+		if end <= 0 || end > count {
+			end = count
+		}
+		var indices []int
+		for i := start; i <= end; i++ {
+			indices = append(indices, i)
+		}
+		return indices, nil
+	}
+
+	var indices []int
+	selected := map[int]bool{}
+	add := func(i int) {
+		if i >= 1 && i <= count && !selected[i] {
+			selected[i] = true
+			indices = append(indices, i)
+		}
+	}
+
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		match := regPlaylistItem.FindStringSubmatch(item)
+		if match == nil || (match[1] == "" && match[3] == "") {
+			return nil, fmt.Errorf("invalid playlist item %q", item)
+		}
+
+		first, _ := strconv.Atoi(match[1])
+		last, _ := strconv.Atoi(match[3])
+		switch {
+		case match[2] == "":
+			last = first
+		case match[1] == "":
+			first = 1
+		case match[3] == "":
+			last = first
+			if first <= count {
+				last = count
+			}
+		}
+
+		// Ranges are clamped to the playlist, the ones past its end are skipped
+		if first > count && last > count {
+			continue
+		}
+		first, last = clampIndex(first, count), clampIndex(last, count)
+
+		step := 1
+		if last < first {
+			step = -1
+		}
+		for i := first; ; i += step {
+			add(i)
+			if i == last {
+				break
+			}
+		}
+	}
+
+	return indices, nil
+}
+
+// Clamp a playlist index to [1, count]
+func clampIndex(i int, count int) int {
+	if i < 1 {
+		return 1
+	}
+	if i > count {
+		return count
+	}
+	return i
+}
+
+// Reorder selected items as requested by options
+func orderPlaylistItems(indices []int, reverse bool, random bool) []int {
+	ordered := make([]int, len(indices))
+	switch {
+	case random:
+		for i, j := range rand.Perm(len(indices)) {
+			ordered[i] = indices[j]
+		}
+	case reverse:
+		for i, index := range indices {
+			ordered[len(indices)-1-i] = index
+		}
+	default:
+		copy(ordered, indices)
+	}
+	return ordered
+}
+
+// Get videos of a playlist selected by options, with their position in the playlist
+func GetPlaylistVideos(ctx context.Context, playlistId string) ([]Video, error) {
+
+	playlist, err := GetPlaylistInfo(ctx, playlistId)
+	if err != nil {
+		return nil, err
+	}
+
+	count := len(playlist.Videos)
+	indices, err := selectPlaylistItems(opts.PlaylistItems, opts.PlaylistStart, opts.PlaylistEnd, count)
+	if err != nil {
+		return nil, err
+	}
+
+	var videos []Video
+	for _, index := range orderPlaylistItems(indices, opts.PlaylistReverse, opts.PlaylistRandom) {
+		video := playlist.Videos[index-1]
+		video.PlaylistItem = &PlaylistItem{
+			PlaylistId:    playlist.Id,
+			PlaylistTitle: playlist.Title,
+			PlaylistIndex: index,
+			PlaylistCount: count,
+		}
+		videos = append(videos, video)
+	}
+
+	return videos, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestExtractPlaylistId(t *testing.T) {
+
+	tests := map[string]string{
+		"https://www.youtube.com/playlist?list=PLFgquLnL59alCl_2TQvOiD5Vgm1hCaGSI":                "PLFgquLnL59alCl_2TQvOiD5Vgm1hCaGSI",
+		"https://www.youtube.com/watch?v=" + videoId + "&list=PLFgquLnL59alCl_2TQvOiD5Vgm1hCaGSI": "PLFgquLnL59alCl_2TQvOiD5Vgm1hCaGSI",
+		"https://www.youtube.com/watch?v=" + videoId:                                              "",
+	}
+	for url, expected := range tests {
+		if id := ExtractPlaylistId(url); id != expected {
+			t.Errorf("%s: expected %q, got %q", url, expected, id)
+		}
+	}
+}
+
+func TestSelectPlaylistItems(t *testing.T) {
+
+	tests := []struct {
+		spec       string
+		start, end int
+		expected   []int
+	}{
+		{"", 1, 0, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}},
+		{"", 3, 5, []int{3, 4, 5}},
+		{"", 10, 20, []int{10, 11, 12}},
+		{"1-3,7,10-", 1, 0, []int{1, 2, 3, 7, 10, 11, 12}},
+		{"-2,2,15", 5, 6, []int{1, 2}},
+		{"5-3", 1, 0, []int{5, 4, 3}},
+		{"20-", 1, 0, nil},
+		{"13-15,0-1", 1, 0, []int{1}},
+		{"11-1000000000", 1, 0, []int{11, 12}},
+		{"1000000000-11", 1, 0, []int{12, 11}},
+	}
+	for _, test := range tests {
+		indices, err := selectPlaylistItems(test.spec, test.start, test.end, 12)
+		if err != nil || !reflect.DeepEqual(indices, test.expected) {
+			t.Errorf("%q %d-%d: expected %v, got %v %v", test.spec, test.start, test.end, test.expected, indices, err)
+		}
+	}
+
+	for _, spec := range []string{"a", "1,,2", "-", "1-2-3"} {
+		if _, err := selectPlaylistItems(spec, 1, 0, 12); err == nil {
+			t.Errorf("%q: expected error", spec)
+		}
+	}
+}
+
+func TestOrderPlaylistItems(t *testing.T) {
+
+	indices := []int{1, 2, 3, 7}
+	if ordered := orderPlaylistItems(indices, true, false); !reflect.DeepEqual(ordered, []int{7, 3, 2, 1}) {
+		t.Errorf("unexpected reverse order %v", ordered)
+	}
+
+	ordered := orderPlaylistItems(indices, false, true)
+	sort.Ints(ordered)
+	if !reflect.DeepEqual(ordered, indices) {
+		t.Errorf("random order lost items: %v", ordered)
+	}
+}
+
+func TestParsePlaylistPage(t *testing.T) {
+
+	page := `<html><head><meta property="og:title" content="Best of &amp; more"></head><body>
+<a href="/watch?v=` + videoId + `&amp;list=PL&amp;index=1">One</a>
+<a href="/watch?v=` + videoId + `&amp;list=PL&amp;index=1">One again</a>
+<a href="/watch?v=9bZkp7q19f0&amp;list=PL&amp;index=2">Two</a>
+<script>{"playlistVideoRenderer":{"videoId":"kJQP7kiw5Fk","index":{"simpleText":"3"},"title":{"runs":[{"text":"Despacito \"Remix\""}]}}}</script>
+<script>{"contents":[{"playlistVideoRenderer":{"videoId":"OPf0YbXqDm0","thumbnail":{"thumbnails":[{"url":"https://i.ytimg.com/vi/OPf0YbXqDm0/hqdefault.jpg","width":168,"height":94}]},"title":{"runs":[{"text":"Uptown "},{"text":"Funk"}]},"index":{"simpleText":"4"}}},{"playlistVideoRenderer":{"videoId":"RgKAFK5djSk","thumbnail":{"thumbnails":[{"url":"x"}]},"title":{"simpleText":"See You Again"}}}]}</script>
+</body></html>`
+
+	playlist := ParsePlaylistPage(page)
+	if playlist.Title != "Best of & more" {
+		t.Errorf("unexpected title %q", playlist.Title)
+	}
+	var ids, titles []string
+	for _, video := range playlist.Videos {
+		ids = append(ids, video.VideoId)
+		titles = append(titles, video.Title)
+	}
+	if !reflect.DeepEqual(ids, []string{videoId, "9bZkp7q19f0", "kJQP7kiw5Fk", "OPf0YbXqDm0", "RgKAFK5djSk"}) {
+		t.Errorf("unexpected videos %v", ids)
+	}
+	if !reflect.DeepEqual(titles, []string{"One", "Two", `Despacito "Remix"`, "Uptown Funk", "See You Again"}) {
+		t.Errorf("unexpected titles %q", titles)
+	}
+}
+
+func TestPlaylistVideoCount(t *testing.T) {
+
+	tests := map[string]int{
+		`"numVideosText":{"runs":[{"text":"1,234"},{"text":" videos"}]}`:                  1234,
+		`"stats":[{"runs":[{"text":"12"},{"text":" videos"}]},{"simpleText":"No views"}]`: 12,
+		`{"playlistVideoRenderer":{"videoId":"kJQP7kiw5Fk"}}`:                             0,
+	}
+	for page, expected := range tests {
+		if count := playlistVideoCount(page); count != expected {
+			t.Errorf("expected %d, got %d", expected, count)
+		}
+	}
+}
+
+func TestPlaylistContinuation(t *testing.T) {
+
+	tests := map[string]string{
+		`{"continuationItemRenderer":{"continuationEndpoint":{"continuationCommand":{"token":"4qmFsgJh","request":"CONTINUATION_REQUEST_TYPE_BROWSE"}}}}`: "4qmFsgJh",
+		`"continuations":[{"nextContinuationData":{"continuation":"EgZ2aWRlb3M","clickTrackingParams":"CB"}}]`:                                            "EgZ2aWRlb3M",
+		`{"playlistVideoRenderer":{"videoId":"kJQP7kiw5Fk"}}`:                                                                                             "",
+	}
+	for page, expected := range tests {
+		if token := playlistContinuation(page); token != expected {
+			t.Errorf("expected %q, got %q", expected, token)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+)
+
+// Run post processing steps on a downloaded file
+// Return the final filename
+func postProcess(ctx context.Context, video *Video, format Format, filename string, out io.Writer) (string, error) {
+
+	var err error
+	step := func(name string) {
+		emitEvent(Event{Type: "postprocess", VideoId: video.VideoId, Step: name, Filename: filename})
+	}
+
+	// Thumbnails
+	var thumbnails []string
+	if opts.WriteThumbnail || opts.WriteAllThumbnails {
+		step("write_thumbnails")
+		thumbnails, err = writeThumbnails(ctx, video, filename, out)
+		if err != nil {
+			fmt.Fprintln(out, "Unable to write thumbnail:", err)
+		}
+	}
+
+	// Subtitles
+	if opts.WriteSubs || opts.WriteAutoSubs {
+		step("write_subtitles")
+		if _, err := writeSubtitles(ctx, video, filename, out); err != nil {
+			fmt.Fprintln(out, "Unable to write subtitles:", err)
+		}
+	}
+
+	// Metadata
+	meta := Metadata{}
+	if opts.EmbedMetadata {
+		meta = NewMetadata(video)
+	}
+	if opts.EmbedThumbnail {
+		meta.Cover, err = loadCover(ctx, video, thumbnails)
+		if err != nil {
+			fmt.Fprintln(out, "Unable to load cover art:", err)
+		} else {
+			meta.CoverMime = http.DetectContentType(meta.Cover)
+		}
+	}
+
+	// Extracted audio is always tagged, in the new file
+	if opts.ExtractAudio {
+		cover := meta
+		meta = NewMetadata(video)
+		meta.Cover, meta.CoverMime = cover.Cover, cover.CoverMime
+
+		step("extract_audio")
+		dest, err := ExtractAudio(filename, format, opts.AudioFormat, meta)
+		if err != nil {
+			return dest, err
+		}
+		if !opts.Json {
+			fmt.Fprintln(out, "Audio extracted to", dest)
+		}
+		filename = dest
+
+	} else {
+		if opts.EmbedMetadata || opts.EmbedThumbnail || opts.EmbedSubs || opts.EmbedChapters {
+			step("embed")
+		}
+		if err := embedFile(ctx, video, format, filename, meta, out); err != nil {
+			fmt.Fprintln(out, "Unable to embed metadata:", err)
+		}
+	}
+
+	// Chapters
+	if opts.SplitChapters {
+		step("split_chapters")
+		if _, err := SplitChapters(video, format, filename, out); err != nil {
+			fmt.Fprintln(out, "Unable to split chapters:", err)
+		}
+	}
+
+	// Info JSON, named after the final file
+	if opts.WriteInfoJson {
+		step("write_info_json")
+		if err := writeInfoJson(video, filename); err != nil {
+			fmt.Fprintln(out, "Unable to write info JSON:", err)
+		}
+	}
+
+	return filename, nil
+}
+
+// Embed metadata, subtitles and chapters requested by options in the downloaded file
+// Subtitles and chapters are muxed with metadata in a single pass
+func embedFile(ctx context.Context, video *Video, format Format, filename string, meta Metadata, out io.Writer) error {
+
+	tags := opts.EmbedMetadata || opts.EmbedThumbnail
+
+	var subtitles []SubtitleTrack
+	if opts.EmbedSubs {
+		var err error
+		if subtitles, err = loadSubtitleTracks(ctx, video); err != nil {
+			fmt.Fprintln(out, "Unable to load subtitles:", err)
+		}
+	}
+	var chapters []Chapter
+	if opts.EmbedChapters {
+		chapters = video.Chapters
+	}
+
+	if len(subtitles) == 0 && len(chapters) == 0 {
+		if tags {
+			return embedMetadata(filename, format.Ext, meta)
+		}
+		return nil
+	}
+
+	if !tags {
+		return embedTracks(filename, format.Ext, subtitles, chapters, nil)
+	}
+
+	// Metadata is still written when tracks can't be
+	err := embedTracks(filename, format.Ext, subtitles, chapters, &meta)
+	if err == nil {
+		return nil
+	}
+	fmt.Fprintln(out, "Unable to embed subtitles and chapters:", err)
+	return embedMetadata(filename, format.Ext, meta)
+}
+
+// Embed subtitles and chapters in a downloaded file depending on its container
+// Metadata is written in the same pass when given
+func embedTracks(filename string, ext string, subtitles []SubtitleTrack, chapters []Chapter, meta *Metadata) error {
+	switch ext {
+	case "mp4", "m4a":
+		return EmbedMP4Tracks(filename, subtitles, chapters, meta)
+	case "webm", "mkv":
+		return EmbedMatroskaTracks(filename, ext, subtitles, chapters, meta)
+	}
+	return fmt.Errorf("can't embed subtitles and chapters in %s files", ext)
+}
+
+// Write a file through a temporary file, renamed once complete
+func writeFileAtomic(filename string, write func(f *os.File) error) error {
+	return replaceFile(filename, nil, write)
+}
+
+// Rewrite a file read through src, src is closed before the rename
+// Windows can't replace a file that is still open
+func replaceFile(filename string, src io.ReaderAt, write func(f *os.File) error) error {
+
+	tmpFilename := filename + ".tmp"
+	f, err := os.Create(tmpFilename)
+	if err != nil {
+		return err
+	}
+
+	err = write(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if closer, ok := src.(io.Closer); ok {
+		closer.Close()
+	}
+	if err != nil {
+		os.Remove(tmpFilename)
+		return err
+	}
+
+	return os.Rename(tmpFilename, filename)
+}
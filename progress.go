@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/dustin/go-humanize"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Progress of a download
+type Progress struct {
+	Filename   string
+	Downloaded int64
+	Total      int64   // Zero when unknown
+	Speed      float64 // Bytes per second
+}
+
+// Remaining time, negative when unknown
+func (p Progress) ETA() time.Duration {
+	if p.Total <= 0 || p.Speed <= 0 {
+		return -1
+	}
+	remaining := float64(p.Total-p.Downloaded) / p.Speed
+	if remaining < 0 {
+		remaining = 0
+	}
+	return time.Duration(remaining * float64(time.Second))
+}
+
+// Receive progress of a download, every downloader feeds one
+type ProgressReporter interface {
+	// Called periodically while downloading
+	Update(p Progress)
+	// Called once the download ended, err is nil on success
+	Finish(err error)
+}
+
+// Reporter printing nothing, used when not printing to a terminal
+type quietProgress struct{}
+
+func (quietProgress) Update(Progress) {}
+func (quietProgress) Finish(error)    {}
+
+// Check if f is a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Width of the terminal, from COLUMNS when set
+func terminalWidth() int {
+	if width, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && width > 0 {
+		return width
+	}
+	return 80
+}
+
+// Format remaining time as mm:ss or h:mm:ss
+func formatETA(d time.Duration) string {
+	if d < 0 {
+		return "--:--"
+	}
+	seconds := int64((d + time.Second - 1) / time.Second)
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	}
+	return fmt.Sprintf("%02d:%02d", seconds/60, seconds%60)
+}
+
+// Width of the bar itself, between brackets
+const progressBarWidth = 20
+
+// Single line progress bar, truncated to width: percent, bar, size, speed, ETA and filename
+func formatProgress(p Progress, width int) string {
+
+	percent, bar := "   ?%", strings.Repeat("-", progressBarWidth)
+	size := humanize.Bytes(uint64(p.Downloaded))
+	if p.Total > 0 {
+		ratio := float64(p.Downloaded) / float64(p.Total)
+		if ratio > 1 {
+			ratio = 1
+		}
+		percent = fmt.Sprintf("%5.1f%%", ratio*100)
+		filled := int(ratio * progressBarWidth)
+		bar = strings.Repeat("=", filled)
+		if filled < progressBarWidth {
+			bar += ">" + strings.Repeat(" ", progressBarWidth-filled-1)
+		}
+		size += " / " + humanize.Bytes(uint64(p.Total))
+	}
+
+	line := fmt.Sprintf("%s [%s] %s  %s/s  ETA %s  %s",
+		percent, bar, size, humanize.Bytes(uint64(p.Speed)), formatETA(p.ETA()), filepath.Base(p.Filename))
+
+	// Lines must not wrap, the display counts them to redraw
+	if runes := []rune(line); len(runes) >= width {
+		line = string(runes[:width-1])
+	}
+	return line
+}
+
+// Progress bars drawn below the console output, one per running download
+// Output written to the display is printed above the bars
+type ProgressDisplay struct {
+	mu      sync.Mutex
+	w       io.Writer
+	width   int
+	bars    []*progressBar
+	lines   int    // Bar lines drawn on screen
+	partial []byte // Output waiting for the end of its line
+}
+
+func NewProgressDisplay(w io.Writer) *ProgressDisplay {
+	return &ProgressDisplay{w: w, width: terminalWidth()}
+}
+
+// Bar of a single download
+type progressBar struct {
+	d        *ProgressDisplay
+	progress Progress
+	started  bool
+}
+
+// Create a bar, drawn once it gets progress
+func (d *ProgressDisplay) Bar() ProgressReporter {
+	return &progressBar{d: d}
+}
+
+func (b *progressBar) Update(p Progress) {
+	d := b.d
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !b.started {
+		b.started = true
+		d.bars = append(d.bars, b)
+	}
+	b.progress = p
+	d.redraw(nil)
+}
+
+func (b *progressBar) Finish(error) {
+	d := b.d
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, bar := range d.bars {
+		if bar == b {
+			d.bars = append(d.bars[:i], d.bars[i+1:]...)
+			d.redraw(nil)
+			return
+		}
+	}
+}
+
+// Clear bars, print text and draw bars again, in a single write to avoid flickering
+func (d *ProgressDisplay) redraw(text []byte) error {
+
+	var buf bytes.Buffer
+	if d.lines > 0 {
+		fmt.Fprintf(&buf, "\r\x1b[%dA\x1b[J", d.lines)
+	}
+	buf.Write(text)
+	for _, bar := range d.bars {
+		buf.WriteString(formatProgress(bar.progress, d.width) + "\n")
+	}
+	d.lines = len(d.bars)
+
+	_, err := buf.WriteTo(d.w)
+	return err
+}
+
+// Print complete lines above the bars
+func (d *ProgressDisplay) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.partial = append(d.partial, p...)
+	end := bytes.LastIndexByte(d.partial, '\n')
+	if end < 0 {
+		return len(p), nil
+	}
+
+	err := d.redraw(d.partial[:end+1])
+	d.partial = append([]byte(nil), d.partial[end+1:]...)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Remove bars and print the remaining output
+func (d *ProgressDisplay) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.bars = nil
+	err := d.redraw(d.partial)
+	d.partial = nil
+	return err
+}
+
+// Display of progress bars, nil when not printing to a terminal
+var progressDisplay *ProgressDisplay
+
+// Create the reporter of the download of a video
+// Progress goes to the terminal bars and to progress events when enabled
+func newProgressReporter(videoId string) ProgressReporter {
+	var reporters multiProgress
+	if progressDisplay != nil {
+		reporters = append(reporters, progressDisplay.Bar())
+	}
+	if eventWriter != nil {
+		reporters = append(reporters, eventProgress{videoId: videoId})
+	}
+	switch len(reporters) {
+	case 0:
+		return quietProgress{}
+	case 1:
+		return reporters[0]
+	}
+	return reporters
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestFormatProgress(t *testing.T) {
+
+	tests := []struct {
+		progress Progress
+		width    int
+		expected string
+	}{
+		{
+			Progress{Filename: "/tmp/video.mp4", Downloaded: 500000, Total: 2000000, Speed: 100000},
+			80,
+			" 25.0% [=====>              ] 500 kB / 2.0 MB  100 kB/s  ETA 00:15  video.mp4",
+		},
+		{
+			Progress{Filename: "video.mp4", Downloaded: 2000000, Total: 2000000, Speed: 100000},
+			80,
+			"100.0% [====================] 2.0 MB / 2.0 MB  100 kB/s  ETA 00:00  video.mp4",
+		},
+		{
+			Progress{Filename: "video.mp4", Downloaded: 500000},
+			80,
+			"   ?% [--------------------] 500 kB  0 B/s  ETA --:--  video.mp4",
+		},
+		{
+			Progress{Filename: "video.mp4", Downloaded: 500000},
+			20,
+			"   ?% [------------",
+		},
+	}
+	for _, test := range tests {
+		if line := formatProgress(test.progress, test.width); line != test.expected {
+			t.Errorf("expected\n%q, got\n%q", test.expected, line)
+		}
+	}
+}
+
+func TestFormatETA(t *testing.T) {
+	tests := map[time.Duration]string{
+		-1:                      "--:--",
+		0:                       "00:00",
+		1500 * time.Millisecond: "00:02",
+		125 * time.Second:       "02:05",
+		3725 * time.Second:      "1:02:05",
+	}
+	for d, expected := range tests {
+		if eta := formatETA(d); eta != expected {
+			t.Errorf("%v: expected %s, got %s", d, expected, eta)
+		}
+	}
+}
+
+func TestProgressDisplay(t *testing.T) {
+
+	var buf bytes.Buffer
+	d := NewProgressDisplay(&buf)
+	d.width = 80
+
+	first, second := d.Bar(), d.Bar()
+	first.Update(Progress{Filename: "a.mp4", Downloaded: 1000, Total: 2000})
+	second.Update(Progress{Filename: "b.mp4", Downloaded: 1000})
+	d.Write([]byte("Downloading "))
+	d.Write([]byte("b.mp4\n"))
+	first.Finish(nil)
+	d.Write([]byte("Done"))
+	d.Close()
+
+	a := formatProgress(Progress{Filename: "a.mp4", Downloaded: 1000, Total: 2000}, 80) + "\n"
+	b := formatProgress(Progress{Filename: "b.mp4", Downloaded: 1000}, 80) + "\n"
+	expected := a +
+		"\r\x1b[1A\x1b[J" + a + b +
+		"\r\x1b[2A\x1b[J" + "Downloading b.mp4\n" + a + b +
+		"\r\x1b[2A\x1b[J" + b +
+		"\r\x1b[1A\x1b[J" + "Done"
+	if buf.String() != expected {
+		t.Errorf("expected\n%q, got\n%q", expected, buf.String())
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rate applied between two times of the day, in minutes since midnight
+// Windows ending before they start wrap around midnight
+type rateWindow struct {
+	Start int
+	End   int
+	Rate  float64 // Bytes per second, 0 is unlimited
+}
+
+// Token bucket limiting the bandwidth of all downloads
+// The bucket holds at most one second of transfer
+type RateLimiter struct {
+	mu       sync.Mutex
+	rate     float64 // Bytes per second, 0 is unlimited
+	schedule []rateWindow
+	tokens   float64
+	last     time.Time
+	now      func() time.Time
+}
+
+// Create a limiter, schedule windows take precedence over the rate
+func NewRateLimiter(rate float64, schedule []rateWindow) *RateLimiter {
+	return &RateLimiter{rate: rate, schedule: schedule, now: time.Now}
+}
+
+// Rate at the given time
+func (l *RateLimiter) Rate(t time.Time) float64 {
+	minutes := t.Hour()*60 + t.Minute()
+	for _, window := range l.schedule {
+		if window.Start <= window.End && minutes >= window.Start && minutes < window.End {
+			return window.Rate
+		}
+		if window.Start > window.End && (minutes >= window.Start || minutes < window.End) {
+			return window.Rate
+		}
+	}
+	return l.rate
+}
+
+// Take n bytes from the bucket, wait until they are available
+// Bytes are reserved right away so concurrent callers queue fairly
+func (l *RateLimiter) WaitN(ctx context.Context, n int) error {
+
+	l.mu.Lock()
+	now := l.now()
+	rate := l.Rate(now)
+	if rate <= 0 {
+		l.tokens, l.last = 0, now
+		l.mu.Unlock()
+		return nil
+	}
+
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * rate
+	}
+	if l.tokens > rate {
+		l.tokens = rate
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	wait := time.Duration(-l.tokens / rate * float64(time.Second))
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Limit the rate of a reader, waits stop when ctx is cancelled
+func (l *RateLimiter) Reader(ctx context.Context, r io.Reader) io.Reader {
+	return &limitedReader{ctx: ctx, r: r, limiter: l}
+}
+
+type limitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *RateLimiter
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if waitErr := r.limiter.WaitN(r.ctx, n); waitErr != nil && err == nil {
+			err = waitErr
+		}
+	}
+	return n, err
+}
+
+// Match sizes like 500K, 4.2M or 1GiB
+var regSize = regexp.MustCompile(`^(\d+(?:\.\d+)?)\s*([kKmMgG]?)(?:i?[bB])?$`)
+
+// Parse a size in bytes, multiples are powers of 1024 like youtube-dl
+func parseSize(size string) (float64, error) {
+
+	match := regSize.FindStringSubmatch(strings.TrimSpace(size))
+	if match == nil {
+		return 0, fmt.Errorf("invalid size %s", size)
+	}
+
+	value, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, err
+	}
+	switch strings.ToUpper(match[2]) {
+	case "K":
+		value *= 1 << 10
+	case "M":
+		value *= 1 << 20
+	case "G":
+		value *= 1 << 30
+	}
+
+	return value, nil
+}
+
+// Parse a rate in bytes per second
+// 0 and "unlimited" disable the limit
+func parseRate(rate string) (float64, error) {
+
+	rate = strings.TrimSpace(rate)
+	if rate == "unlimited" {
+		return 0, nil
+	}
+
+	value, err := parseSize(rate)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %s", rate)
+	}
+	return value, nil
+}
+
+// Parse hh:mm in minutes since midnight
+func parseTimeOfDay(value string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %s", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Parse a schedule like "01:00-06:00=unlimited,09:00-18:00=500K"
+func parseRateSchedule(schedule string) ([]rateWindow, error) {
+
+	var windows []rateWindow
+	for _, entry := range strings.Split(schedule, ",") {
+
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, "=", 2)
+		times := strings.SplitN(parts[0], "-", 2)
+		if len(parts) != 2 || len(times) != 2 {
+			return nil, fmt.Errorf("invalid schedule entry %s", entry)
+		}
+
+		var window rateWindow
+		var err error
+		if window.Start, err = parseTimeOfDay(times[0]); err != nil {
+			return nil, err
+		}
+		if window.End, err = parseTimeOfDay(times[1]); err != nil {
+			return nil, err
+		}
+		if window.Rate, err = parseRate(parts[1]); err != nil {
+			return nil, err
+		}
+		windows = append(windows, window)
+	}
+
+	return windows, nil
+}
+
+// Limiter shared by all downloads, nil when there is no limit
+var rateLimiter *RateLimiter
+
+// Create the shared limiter from options
+func setupRateLimiter() error {
+
+	if opts.LimitRate == "" && opts.LimitRateSchedule == "" {
+		return nil
+	}
+
+	var rate float64
+	if opts.LimitRate != "" {
+		var err error
+		if rate, err = parseRate(opts.LimitRate); err != nil {
+			return err
+		}
+	}
+	schedule, err := parseRateSchedule(opts.LimitRateSchedule)
+	if err != nil {
+		return err
+	}
+
+	rateLimiter = NewRateLimiter(rate, schedule)
+	return nil
+}
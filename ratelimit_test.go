@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+
+	tests := map[string]float64{
+		"500":       500,
+		"50K":       50 * 1024,
+		"4.5M":      4.5 * 1024 * 1024,
+		"1GiB":      1 << 30,
+		"2mb":       2 << 20,
+		"unlimited": 0,
+	}
+	for rate, expected := range tests {
+		if value, err := parseRate(rate); err != nil || value != expected {
+			t.Errorf("%s: expected %f, got %f %v", rate, expected, value, err)
+		}
+	}
+
+	if _, err := parseRate("fast"); err == nil {
+		t.Errorf("expected error for invalid rate")
+	}
+}
+
+func TestRateSchedule(t *testing.T) {
+
+	schedule, err := parseRateSchedule("01:00-06:00=unlimited,22:30-00:30=1M")
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter := NewRateLimiter(100, schedule)
+
+	tests := map[string]float64{
+		"03:00": 0,
+		"06:00": 100,
+		"23:00": 1 << 20,
+		"00:15": 1 << 20,
+		"12:00": 100,
+	}
+	for clock, expected := range tests {
+		now, _ := time.Parse("15:04", clock)
+		if rate := limiter.Rate(now); rate != expected {
+			t.Errorf("%s: expected %f, got %f", clock, expected, rate)
+		}
+	}
+
+	if _, err := parseRateSchedule("01:00=1M"); err == nil {
+		t.Errorf("expected error for invalid schedule")
+	}
+}
+
+func TestRateLimiterWait(t *testing.T) {
+
+	// Fake clock, the bucket starts empty
+	now := time.Now()
+	limiter := NewRateLimiter(1000, nil)
+	limiter.now = func() time.Time { return now }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Reservations stack up while the clock doesn't move
+	if err := limiter.WaitN(ctx, 1000); err != context.Canceled {
+		t.Errorf("expected to wait, got %v", err)
+	}
+
+	// One second later, the debt is paid and half a second of burst is available
+	now = now.Add(1500 * time.Millisecond)
+	if err := limiter.WaitN(ctx, 500); err != nil {
+		t.Errorf("expected no wait, got %v", err)
+	}
+
+	// Bucket never holds more than one second
+	now = now.Add(time.Hour)
+	if err := limiter.WaitN(ctx, 1000); err != nil {
+		t.Errorf("expected no wait, got %v", err)
+	}
+	if err := limiter.WaitN(ctx, 1); err != context.Canceled {
+		t.Errorf("expected to wait, got %v", err)
+	}
+}
+
+func TestLimitedReaderCancel(t *testing.T) {
+
+	limiter := NewRateLimiter(1, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Waiting for the second byte stops right away
+	r := limiter.Reader(ctx, strings.NewReader("ab"))
+	buf := make([]byte, 2)
+	if _, err := r.Read(buf); err != context.Canceled {
+		t.Errorf("expected cancellation, got %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Error of a http request answered with a bad status
+type httpError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *httpError) Error() string {
+	return e.Status
+}
+
+// Check if a request may succeed if tried again
+// Timeouts, reset or refused connections, truncated bodies, rate limiting and server errors are transient
+func isTransient(err error) bool {
+	switch e := err.(type) {
+	case *httpError:
+		return e.StatusCode == 429 || e.StatusCode >= 500
+	case *url.Error:
+		return isTransient(e.Err)
+	case *net.OpError:
+		return e.Timeout() || isTransient(e.Err)
+	case *os.SyscallError:
+		return isTransient(e.Err)
+	case syscall.Errno:
+		return e == syscall.ECONNRESET || e == syscall.ECONNREFUSED
+	case net.Error:
+		return e.Timeout()
+	}
+	return err == io.ErrUnexpectedEOF
+}
+
+// Media urls answer 403 or 410 once expired
+func isExpired(err error) bool {
+	e, ok := err.(*httpError)
+	return ok && (e.StatusCode == 403 || e.StatusCode == 410)
+}
+
+// Expired media urls replaced in a row before giving up
+const maxRefreshes = 3
+
+// Check the expire param of a media url, expired a minute early
+func urlExpired(mediaUrl string) bool {
+	u, err := url.Parse(mediaUrl)
+	if err != nil {
+		return false
+	}
+	expire, err := strconv.ParseInt(u.Query().Get("expire"), 10, 64)
+	if err != nil {
+		return false
+	}
+	return time.Now().Add(time.Minute).Unix() >= expire
+}
+
+// Delay before a retry, exponential with jitter: half of it is random
+// Start at one second and never exceed 30 seconds
+func backoff(attempt int) time.Duration {
+	delay := 30 * time.Second
+	if attempt < 5 {
+		delay = time.Second << uint(attempt)
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// Returned to retry right away, the attempt isn't counted
+var errRetryNow = errors.New("retry now")
+
+// Sleep between retries unless cancelled, replaced in tests
+var retrySleep = func(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Call fn until it succeeds, fails with an error that isn't transient or retries are exhausted
+// Retries are reported to out when given, cancellation stops them
+func retry(ctx context.Context, out io.Writer, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+
+		err := fn()
+		if err != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err == errRetryNow {
+			attempt--
+			continue
+		}
+		if err == nil || !isTransient(err) || attempt >= opts.Retries {
+			return err
+		}
+
+		delay := backoff(attempt)
+		if out != nil {
+			fmt.Fprintf(out, "%v, retrying in %v (%d/%d)\n", err, delay.Round(time.Millisecond), attempt+1, opts.Retries)
+		}
+		if err := retrySleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDownloadPageRetry(t *testing.T) {
+
+	sleep, retries := retrySleep, opts.Retries
+	defer func() { retrySleep, opts.Retries = sleep, retries }()
+
+	var delays []time.Duration
+	retrySleep = func(ctx context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}
+	opts.Retries = 3
+
+	// Fails twice before answering
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls++; calls <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	defer server.Close()
+
+	page, err := downloadPage(context.Background(), server.URL)
+	if err != nil || page != "ok" {
+		t.Fatalf("expected ok, got %q %v", page, err)
+	}
+	if len(delays) != 2 {
+		t.Errorf("expected 2 retries, got %d", len(delays))
+	}
+
+	// Not found isn't retried
+	calls = 0
+	notFound := httptest.NewServer(http.NotFoundHandler())
+	defer notFound.Close()
+	if _, err := downloadPage(context.Background(), notFound.URL); err == nil || isTransient(err) {
+		t.Errorf("expected a permanent error, got %v", err)
+	}
+	if len(delays) != 2 {
+		t.Errorf("expected no more retries, got %d", len(delays)-2)
+	}
+}
+
+func TestRetryExhausted(t *testing.T) {
+
+	sleep, retries := retrySleep, opts.Retries
+	defer func() { retrySleep, opts.Retries = sleep, retries }()
+
+	retrySleep = func(context.Context, time.Duration) error { return nil }
+	opts.Retries = 4
+
+	var calls int
+	err := retry(context.Background(), nil, func() error {
+		calls++
+		return syscall.ECONNRESET
+	})
+	if err == nil || calls != 5 {
+		t.Errorf("expected 5 calls and an error, got %d %v", calls, err)
+	}
+}
+
+// Network error reporting a timeout
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestErrorClassification(t *testing.T) {
+
+	tests := []struct {
+		err       error
+		transient bool
+		expired   bool
+	}{
+		{nil, false, false},
+		{&net.OpError{Op: "read", Err: timeoutError{}}, true, false},
+		{&url.Error{Op: "Get", Err: &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}}, true, false},
+		{&net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, true, false},
+		{io.ErrUnexpectedEOF, true, false},
+		{errors.New("timeout"), false, false},
+		{io.EOF, false, false},
+		{&url.Error{Op: "Get", Err: errors.New("unsupported protocol scheme")}, false, false},
+		{&net.DNSError{Err: "no such host", Name: "example.invalid"}, false, false},
+		{context.Canceled, false, false},
+		{&httpError{StatusCode: 500}, true, false},
+		{&httpError{StatusCode: 429}, true, false},
+		{&httpError{StatusCode: 404}, false, false},
+		{&httpError{StatusCode: 403}, false, true},
+		{&httpError{StatusCode: 410}, false, true},
+	}
+	for _, test := range tests {
+		if isTransient(test.err) != test.transient || isExpired(test.err) != test.expired {
+			t.Errorf("%v: expected transient %v and expired %v", test.err, test.transient, test.expired)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempt := 0; attempt < 10; attempt++ {
+		max := 30 * time.Second
+		if attempt < 5 {
+			max = time.Second << uint(attempt)
+		}
+		if d := backoff(attempt); d < max/2 || d > max {
+			t.Errorf("attempt %d: %v out of [%v, %v]", attempt, d, max/2, max)
+		}
+	}
+}
+
+func TestUrlExpired(t *testing.T) {
+
+	now := time.Now().Unix()
+	tests := map[string]bool{
+		"https://example.com/videoplayback?expire=" + strconv.FormatInt(now-10, 10):   true,
+		"https://example.com/videoplayback?expire=" + strconv.FormatInt(now+3600, 10): false,
+		"https://example.com/videoplayback":                                           false,
+	}
+	for url, expected := range tests {
+		if urlExpired(url) != expected {
+			t.Errorf("%s: expected %v", url, expected)
+		}
+	}
+}
+
+func TestRetryCancelled(t *testing.T) {
+
+	retries := opts.Retries
+	defer func() { opts.Retries = retries }()
+	opts.Retries = 10
+
+	// Cancelled while waiting for the first retry
+	ctx, cancel := context.WithCancel(context.Background())
+	var calls int
+	err := retry(ctx, nil, func() error {
+		calls++
+		cancel()
+		return syscall.ECONNRESET
+	})
+	if err != context.Canceled || calls != 1 {
+		t.Errorf("expected cancellation after 1 call, got %d %v", calls, err)
+	}
+}
+
+func TestRetryNow(t *testing.T) {
+
+	sleep, retries := retrySleep, opts.Retries
+	defer func() { retrySleep, opts.Retries = sleep, retries }()
+
+	var sleeps int
+	retrySleep = func(context.Context, time.Duration) error {
+		sleeps++
+		return nil
+	}
+	opts.Retries = 1
+
+	// Immediate retries neither sleep nor use up retries
+	var calls int
+	err := retry(context.Background(), nil, func() error {
+		if calls++; calls <= 3 {
+			return errRetryNow
+		}
+		if calls == 4 {
+			return syscall.ECONNRESET
+		}
+		return nil
+	})
+	if err != nil || calls != 5 || sleeps != 1 {
+		t.Errorf("expected 5 calls and 1 sleep, got %d %d %v", calls, sleeps, err)
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Cancel the returned context on SIGINT or SIGTERM so downloads stop cleanly
+// A second signal exits right away
+func handleSignals() context.Context {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-signals
+		fmt.Fprintln(os.Stderr, "Interrupted, stopping downloads (press Ctrl-C again to quit now)")
+		cancel()
+		<-signals
+		os.Exit(130)
+	}()
+
+	return ctx
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cue of a subtitle
+type Cue struct {
+	Start time.Duration
+	End   time.Duration
+	Text  string
+}
+
+// json3 captions
+type json3Captions struct {
+	Events []struct {
+		Start    int64 `json:"tStartMs"`
+		Duration int64 `json:"dDurationMs"`
+		Segs     []struct {
+			Text string `json:"utf8"`
+		} `json:"segs"`
+	} `json:"events"`
+}
+
+// srv3 captions
+type srv3Captions struct {
+	Paragraphs []struct {
+		Start    int64  `xml:"t,attr"`
+		Duration int64  `xml:"d,attr"`
+		Text     string `xml:",chardata"`
+		Segs     []struct {
+			Text string `xml:",chardata"`
+		} `xml:"s"`
+	} `xml:"body>p"`
+}
+
+// Parse captions downloaded in the given format
+func ParseSubtitle(data []byte, format string) ([]Cue, error) {
+
+	var cues []Cue
+	switch format {
+	case "json3":
+		var captions json3Captions
+		if err := json.Unmarshal(data, &captions); err != nil {
+			return nil, err
+		}
+		for _, event := range captions.Events {
+			var text string
+			for _, seg := range event.Segs {
+				text += seg.Text
+			}
+			cues = append(cues, Cue{
+				Start: time.Duration(event.Start) * time.Millisecond,
+				End:   time.Duration(event.Start+event.Duration) * time.Millisecond,
+				Text:  text,
+			})
+		}
+
+	case "srv3":
+		var captions srv3Captions
+		if err := xml.Unmarshal(data, &captions); err != nil {
+			return nil, err
+		}
+		for _, p := range captions.Paragraphs {
+			text := p.Text
+			for _, seg := range p.Segs {
+				text += seg.Text
+			}
+			cues = append(cues, Cue{
+				Start: time.Duration(p.Start) * time.Millisecond,
+				End:   time.Duration(p.Start+p.Duration) * time.Millisecond,
+				Text:  text,
+			})
+		}
+
+	case "vtt":
+		var err error
+		if cues, err = parseVTT(data); err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, fmt.Errorf("unknown subtitle format %s", format)
+	}
+
+	return normalizeCues(cues), nil
+}
+
+// Match WebVTT timing lines
+var regVTTTiming = regexp.MustCompile(`^((?:\d+:)?\d+:\d+\.\d+)\s+-->\s+((?:\d+:)?\d+:\d+\.\d+)`)
+
+// Match WebVTT inline tags and timestamps
+var regVTTTags = regexp.MustCompile(`<[^>]*>`)
+
+// Parse WebVTT cues, styling is dropped
+func parseVTT(data []byte) ([]Cue, error) {
+
+	lines := strings.Split(strings.Replace(string(data), "\r\n", "\n", -1), "\n")
+	if len(lines) == 0 || !strings.HasPrefix(lines[0], "WEBVTT") {
+		return nil, fmt.Errorf("not a WebVTT file")
+	}
+
+	var cues []Cue
+	var cue *Cue
+	for _, line := range lines {
+
+		if match := regVTTTiming.FindStringSubmatch(line); match != nil {
+			start, err := parseVTTTimestamp(match[1])
+			if err != nil {
+				return nil, err
+			}
+			end, err := parseVTTTimestamp(match[2])
+			if err != nil {
+				return nil, err
+			}
+			cues = append(cues, Cue{Start: start, End: end})
+			cue = &cues[len(cues)-1]
+			continue
+		}
+
+		// Blank line ends the cue
+		if strings.TrimSpace(line) == "" {
+			cue = nil
+			continue
+		}
+
+		if cue != nil {
+			text := regVTTTags.ReplaceAllString(line, "")
+			if cue.Text != "" {
+				cue.Text += "\n"
+			}
+			cue.Text += unescapeVTT(text)
+		}
+	}
+
+	return cues, nil
+}
+
+// Parse hh:mm:ss.ttt or mm:ss.ttt
+func parseVTTTimestamp(timestamp string) (time.Duration, error) {
+
+	var values []int
+	for _, part := range strings.FieldsFunc(timestamp, func(r rune) bool { return r == ':' || r == '.' }) {
+		value, err := strconv.Atoi(part)
+		if err != nil {
+			return 0, err
+		}
+		values = append(values, value)
+	}
+	if len(values) < 3 {
+		return 0, fmt.Errorf("invalid timestamp %s", timestamp)
+	}
+
+	// Milliseconds, then seconds, minutes and hours
+	d := time.Duration(values[len(values)-1]) * time.Millisecond
+	units := []time.Duration{time.Second, time.Minute, time.Hour}
+	for i, value := range values[:len(values)-1] {
+		d += time.Duration(value) * units[len(values)-2-i]
+	}
+
+	return d, nil
+}
+
+func unescapeVTT(text string) string {
+	return strings.NewReplacer("&amp;", "&", "&lt;", "<", "&gt;", ">", "&nbsp;", " ").Replace(text)
+}
+
+func escapeVTT(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+// Sort cues, drop empty ones and fix missing end times
+func normalizeCues(cues []Cue) []Cue {
+
+	var result []Cue
+	for _, cue := range cues {
+		cue.Text = strings.TrimSpace(cue.Text)
+		if cue.Text != "" {
+			result = append(result, cue)
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Start < result[j].Start
+	})
+
+	for i := range result {
+		if result[i].End > result[i].Start {
+			continue
+		}
+		if i+1 < len(result) && result[i+1].Start > result[i].Start {
+			result[i].End = result[i+1].Start
+		} else {
+			result[i].End = result[i].Start + 2*time.Second
+		}
+	}
+
+	return result
+}
+
+// Split a duration in hours, minutes, seconds and milliseconds
+func splitDuration(d time.Duration) (h, m, s, ms int64) {
+	total := int64(d / time.Millisecond)
+	return total / 3600000, total / 60000 % 60, total / 1000 % 60, total % 1000
+}
+
+func formatSRTTimestamp(d time.Duration) string {
+	h, m, s, ms := splitDuration(d)
+	return fmt.Sprintf("%02d:%02d:%02d,%03d", h, m, s, ms)
+}
+
+func formatVTTTimestamp(d time.Duration) string {
+	h, m, s, ms := splitDuration(d)
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", h, m, s, ms)
+}
+
+func formatASSTimestamp(d time.Duration) string {
+	h, m, s, ms := splitDuration(d)
+	return fmt.Sprintf("%d:%02d:%02d.%02d", h, m, s, ms/10)
+}
+
+// Header of ASS files, with a single default style
+const assHeader = `[Script Info]
+ScriptType: v4.00+
+PlayResX: 384
+PlayResY: 288
+WrapStyle: 0
+
+[V4+ Styles]
+Format: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, OutlineColour, BackColour, Bold, Italic, Underline, StrikeOut, ScaleX, ScaleY, Spacing, Angle, BorderStyle, Outline, Shadow, Alignment, MarginL, MarginR, MarginV, Encoding
+Style: Default,Arial,16,&H00FFFFFF,&H000000FF,&H00000000,&H80000000,0,0,0,0,100,100,0,0,1,1,0,2,10,10,10,1
+
+[Events]
+Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text
+`
+
+// Convert cues to srt, vtt, ass or txt
+func FormatSubtitle(cues []Cue, format string) ([]byte, error) {
+
+	var buf bytes.Buffer
+	switch format {
+	case "srt":
+		for i, cue := range cues {
+			fmt.Fprintf(&buf, "%d\n%s --> %s\n%s\n\n", i+1,
+				formatSRTTimestamp(cue.Start), formatSRTTimestamp(cue.End), cue.Text)
+		}
+
+	case "vtt":
+		buf.WriteString("WEBVTT\n\n")
+		for _, cue := range cues {
+			fmt.Fprintf(&buf, "%s --> %s\n%s\n\n",
+				formatVTTTimestamp(cue.Start), formatVTTTimestamp(cue.End), escapeVTT(cue.Text))
+		}
+
+	case "ass":
+		buf.WriteString(assHeader)
+		for _, cue := range cues {
+			text := strings.Replace(cue.Text, "\n", `\N`, -1)
+			fmt.Fprintf(&buf, "Dialogue: 0,%s,%s,Default,,0,0,0,,%s\n",
+				formatASSTimestamp(cue.Start), formatASSTimestamp(cue.End), text)
+		}
+
+	case "txt":
+		// Automatic captions repeat lines while they scroll
+		var last string
+		for _, cue := range cues {
+			for _, line := range strings.Split(cue.Text, "\n") {
+				line = strings.TrimSpace(line)
+				if line != "" && line != last {
+					buf.WriteString(line + "\n")
+					last = line
+				}
+			}
+		}
+
+	default:
+		return nil, fmt.Errorf("unknown subtitle format %s", format)
+	}
+
+	return buf.Bytes(), nil
+}
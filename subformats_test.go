@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSubtitle(t *testing.T) {
+
+	json3 := `{"events":[
+		{"tStartMs":1500,"dDurationMs":2000,"segs":[{"utf8":"Hello "},{"utf8":"world"}]},
+		{"tStartMs":0,"dDurationMs":1000,"segs":[{"utf8":"First"}]},
+		{"tStartMs":4000,"dDurationMs":0,"segs":[{"utf8":"\n"}]}
+	]}`
+	cues, err := ParseSubtitle([]byte(json3), "json3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cues) != 2 || cues[0].Text != "First" || cues[1].Text != "Hello world" {
+		t.Fatalf("unexpected json3 cues %v", cues)
+	}
+	if cues[1].Start != 1500*time.Millisecond || cues[1].End != 3500*time.Millisecond {
+		t.Errorf("unexpected json3 timing %v - %v", cues[1].Start, cues[1].End)
+	}
+
+	vtt := "WEBVTT\nKind: captions\n\n00:01.000 --> 00:02.500 align:start\n<c>Tom &amp; Jerry</c>\nsecond line\n\n1:00:00.250 --> 1:00:01.000\nLate\n"
+	cues, err = ParseSubtitle([]byte(vtt), "vtt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cues) != 2 || cues[0].Text != "Tom & Jerry\nsecond line" {
+		t.Fatalf("unexpected vtt cues %v", cues)
+	}
+	if cues[1].Start != time.Hour+250*time.Millisecond {
+		t.Errorf("unexpected vtt start %v", cues[1].Start)
+	}
+
+	srv3 := `<timedtext format="3"><body><p t="200" d="800">Plain</p><p t="1000" d="500"><s>Seg</s><s> ments</s></p></body></timedtext>`
+	cues, err = ParseSubtitle([]byte(srv3), "srv3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cues) != 2 || cues[0].Text != "Plain" || cues[1].Text != "Seg ments" {
+		t.Fatalf("unexpected srv3 cues %v", cues)
+	}
+}
+
+func TestFormatSubtitle(t *testing.T) {
+
+	cues := []Cue{
+		{Start: 1500 * time.Millisecond, End: 3*time.Second + 20*time.Millisecond, Text: "Hello\nworld"},
+		{Start: time.Hour + 61*time.Second, End: time.Hour + 62*time.Second, Text: "a < b"},
+	}
+
+	tests := map[string]string{
+		"srt": "1\n00:00:01,500 --> 00:00:03,020\nHello\nworld\n\n2\n01:01:01,000 --> 01:01:02,000\na < b\n\n",
+		"vtt": "WEBVTT\n\n00:00:01.500 --> 00:00:03.020\nHello\nworld\n\n01:01:01.000 --> 01:01:02.000\na &lt; b\n\n",
+		"ass": assHeader +
+			"Dialogue: 0,0:00:01.50,0:00:03.02,Default,,0,0,0,,Hello\\Nworld\n" +
+			"Dialogue: 0,1:01:01.00,1:01:02.00,Default,,0,0,0,,a < b\n",
+		"txt": "Hello\nworld\na < b\n",
+	}
+
+	for format, expected := range tests {
+		data, err := FormatSubtitle(cues, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != expected {
+			t.Errorf("%s: expected %q, got %q", format, expected, data)
+		}
+	}
+}
+
+func TestSelectSubtitles(t *testing.T) {
+
+	subtitles := []Subtitle{
+		{Lang: "en", Auto: true},
+		{Lang: "en"},
+		{Lang: "en-GB"},
+		{Lang: "fr", Auto: true},
+	}
+
+	selected, _ := SelectSubtitles(subtitles, "en", true, true)
+	if len(selected) != 1 || selected[0].Auto {
+		t.Errorf("expected manual en subtitle, got %v", selected)
+	}
+
+	selected, _ = SelectSubtitles(subtitles, "en.*,fr", false, true)
+	if len(selected) != 2 || selected[0].Lang != "en" || selected[1].Lang != "fr" {
+		t.Errorf("expected automatic en and fr captions, got %v", selected)
+	}
+
+	if selected, _ = SelectSubtitles(subtitles, "all", true, false); len(selected) != 2 {
+		t.Errorf("expected all manual subtitles, got %v", selected)
+	}
+
+	if _, err := SelectSubtitles(subtitles, "en,(fr", true, false); err == nil {
+		t.Errorf("expected error for invalid language regexp")
+	}
+}
+
+func TestWriteSubtitlesFailedLanguage(t *testing.T) {
+
+	saved := opts
+	defer func() { opts = saved }()
+	opts.SubLangs, opts.WriteSubs, opts.SubFormat, opts.ConvertSubs = "all", true, "vtt", ""
+
+	// French is missing, English is still written
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fr" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, "WEBVTT\n")
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "gotubedl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	video := &Video{VideoId: videoId, Subtitles: []Subtitle{{Lang: "fr", Url: server.URL + "/fr"}, {Lang: "en", Url: server.URL + "/en"}}}
+	files, err := writeSubtitles(context.Background(), video, filepath.Join(dir, "video.mp4"), ioutil.Discard)
+	if err == nil || !strings.Contains(err.Error(), "fr") {
+		t.Errorf("expected error for fr subtitles, got %v", err)
+	}
+	if len(files) != 1 || filepath.Base(files[0]) != "video.en.vtt" {
+		t.Errorf("expected en subtitles to be written, got %v", files)
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/ryanuber/columnize"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Caption track of a video
+type Subtitle struct {
+	Lang string `json:"lang"`
+	Name string `json:"name"`
+	Url  string `json:"url"`
+	Auto bool   `json:"auto"` // Automatic captions
+}
+
+// Formats youtube can serve captions in
+var subtitleFormats = []string{"json3", "srv3", "vtt"}
+
+// Url of the caption track in the given format
+func (s Subtitle) FormatUrl(format string) string {
+	u, err := url.Parse(s.Url)
+	if err != nil {
+		return s.Url
+	}
+	query := u.Query()
+	query.Set("fmt", format)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// Check if language matches one of the comma separated patterns
+// Patterns are regexps matching the whole language code, "all" matches every language
+func matchLang(lang string, patterns string) (bool, error) {
+	for _, pattern := range strings.Split(patterns, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "all" {
+			return true, nil
+		}
+		match, err := regexp.MatchString("^(?:"+pattern+")$", lang)
+		if err != nil {
+			return false, fmt.Errorf("invalid subtitle language %s: %v", pattern, err)
+		}
+		if match {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Select subtitles to write, one per language
+// Subtitles written by hand are preferred over automatic captions
+func SelectSubtitles(subtitles []Subtitle, langs string, manual bool, auto bool) ([]Subtitle, error) {
+
+	var selected []Subtitle
+	seen := map[string]bool{}
+	for _, wantAuto := range []bool{false, true} {
+		if (!wantAuto && !manual) || (wantAuto && !auto) {
+			continue
+		}
+		for _, subtitle := range subtitles {
+			if subtitle.Auto != wantAuto || seen[subtitle.Lang] {
+				continue
+			}
+			match, err := matchLang(subtitle.Lang, langs)
+			if err != nil {
+				return nil, err
+			}
+			if match {
+				seen[subtitle.Lang] = true
+				selected = append(selected, subtitle)
+			}
+		}
+	}
+
+	return selected, nil
+}
+
+// Download subtitles next to the video file, converting them if requested
+// A failed language doesn't stop the others, failures are reported together
+// Return the list of written files
+func writeSubtitles(ctx context.Context, video *Video, filename string, out io.Writer) ([]string, error) {
+
+	var files []string
+	subtitles, err := SelectSubtitles(video.Subtitles, opts.SubLangs, opts.WriteSubs, opts.WriteAutoSubs)
+	if err != nil {
+		return nil, err
+	}
+	if len(subtitles) == 0 {
+		return nil, fmt.Errorf("no subtitles available for languages %s", opts.SubLangs)
+	}
+
+	var failed []string
+	for _, subtitle := range subtitles {
+
+		dest, err := writeSubtitle(ctx, subtitle, filename)
+		if err != nil {
+			if ctx.Err() != nil {
+				return files, ctx.Err()
+			}
+			fmt.Fprintf(out, "Unable to write %s subtitles: %v\n", subtitle.Lang, err)
+			failed = append(failed, subtitle.Lang)
+			continue
+		}
+
+		if !opts.Json {
+			fmt.Fprintln(out, "Subtitle saved to", dest)
+		}
+		files = append(files, dest)
+	}
+
+	if len(failed) > 0 {
+		return files, fmt.Errorf("subtitles not written for %s", strings.Join(failed, ", "))
+	}
+	return files, nil
+}
+
+// Download a subtitle next to the video file, converting it if requested
+// Return the written file
+func writeSubtitle(ctx context.Context, subtitle Subtitle, filename string) (string, error) {
+
+	raw, err := downloadPage(ctx, subtitle.FormatUrl(opts.SubFormat))
+	if err != nil {
+		return "", err
+	}
+	data, ext := []byte(raw), opts.SubFormat
+
+	// Convert subtitle if needed
+	if opts.ConvertSubs != "" {
+		cues, err := ParseSubtitle(data, opts.SubFormat)
+		if err != nil {
+			return "", err
+		}
+		if data, err = FormatSubtitle(cues, opts.ConvertSubs); err != nil {
+			return "", err
+		}
+		ext = opts.ConvertSubs
+	}
+
+	dest := replaceExt(filename, subtitle.Lang+"."+ext)
+	return dest, ioutil.WriteFile(dest, data, 0644)
+}
+
+// Print subtitles and automatic captions
+func PrintSubtitles(subtitles []Subtitle, out io.Writer) {
+
+	if opts.Json {
+		var jsonOutput []byte
+		if opts.PrettyJson {
+			jsonOutput, _ = json.MarshalIndent(subtitles, "", "\t")
+		} else {
+			jsonOutput, _ = json.Marshal(subtitles)
+		}
+		fmt.Fprintln(out, string(jsonOutput))
+		return
+	}
+
+	for _, auto := range []bool{false, true} {
+
+		lines := []string{"Language | Name | Formats"}
+		for _, subtitle := range subtitles {
+			if subtitle.Auto == auto {
+				lines = append(lines, strings.Join([]string{
+					subtitle.Lang, subtitle.Name, strings.Join(subtitleFormats, ", "),
+				}, " | "))
+			}
+		}
+
+		if auto {
+			fmt.Fprintln(out, "Available automatic captions:")
+		} else {
+			fmt.Fprintln(out, "Available subtitles:")
+		}
+		if len(lines) == 1 {
+			fmt.Fprintln(out, "None")
+		} else {
+			fmt.Fprintln(out, columnize.SimpleFormat(lines))
+		}
+	}
+}
+
+// Subtitle downloaded and parsed, ready to be embedded
+type SubtitleTrack struct {
+	Subtitle
+	Cues []Cue
+}
+
+// Download and parse subtitles to embed in the video file
+func loadSubtitleTracks(ctx context.Context, video *Video) ([]SubtitleTrack, error) {
+
+	// Embedding alone implies subtitles written by hand
+	manual := opts.WriteSubs || !opts.WriteAutoSubs
+	subtitles, err := SelectSubtitles(video.Subtitles, opts.SubLangs, manual, opts.WriteAutoSubs)
+	if err != nil {
+		return nil, err
+	}
+	if len(subtitles) == 0 {
+		return nil, fmt.Errorf("no subtitles available for languages %s", opts.SubLangs)
+	}
+
+	// json3 has the most accurate timings
+	var tracks []SubtitleTrack
+	for _, subtitle := range subtitles {
+		raw, err := downloadPage(ctx, subtitle.FormatUrl("json3"))
+		if err != nil {
+			return nil, err
+		}
+		cues, err := ParseSubtitle([]byte(raw), "json3")
+		if err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, SubtitleTrack{Subtitle: subtitle, Cues: cues})
+	}
+
+	return tracks, nil
+}
+
+// ISO 639-2 codes of ISO 639-1 languages, terminology form
+var iso639Codes = map[string]string{
+	"af": "afr", "am": "amh", "ar": "ara", "az": "aze", "be": "bel", "bg": "bul",
+	"bn": "ben", "bs": "bos", "ca": "cat", "cs": "ces", "cy": "cym", "da": "dan",
+	"de": "deu", "el": "ell", "en": "eng", "eo": "epo", "es": "spa", "et": "est",
+	"eu": "eus", "fa": "fas", "fi": "fin", "fr": "fra", "ga": "gle", "gl": "glg",
+	"gu": "guj", "he": "heb", "hi": "hin", "hr": "hrv", "hu": "hun", "hy": "hye",
+	"id": "ind", "is": "isl", "it": "ita", "ja": "jpn", "jv": "jav", "ka": "kat",
+	"kk": "kaz", "km": "khm", "kn": "kan", "ko": "kor", "ky": "kir", "la": "lat",
+	"lo": "lao", "lt": "lit", "lv": "lav", "mk": "mkd", "ml": "mal", "mn": "mon",
+	"mr": "mar", "ms": "msa", "my": "mya", "ne": "nep", "nl": "nld", "no": "nor",
+	"pa": "pan", "pl": "pol", "pt": "por", "ro": "ron", "ru": "rus", "si": "sin",
+	"sk": "slk", "sl": "slv", "sq": "sqi", "sr": "srp", "sv": "swe", "sw": "swa",
+	"ta": "tam", "te": "tel", "th": "tha", "tl": "tgl", "tr": "tur", "uk": "ukr",
+	"ur": "urd", "uz": "uzb", "vi": "vie", "zh": "zho", "zu": "zul",
+}
+
+// Bibliographic form of ISO 639-2 codes when it differs, used by matroska
+var iso639Bibliographic = map[string]string{
+	"ces": "cze", "cym": "wel", "deu": "ger", "ell": "gre", "eus": "baq",
+	"fas": "per", "fra": "fre", "hye": "arm", "isl": "ice", "kat": "geo",
+	"mkd": "mac", "msa": "may", "mya": "bur", "nld": "dut", "ron": "rum",
+	"slk": "slo", "sqi": "alb", "zho": "chi",
+}
+
+// ISO 639-2 code of a youtube language code (en, pt-BR, zh-Hans), und when unknown
+func iso639Code(lang string, bibliographic bool) string {
+
+	primary := strings.ToLower(strings.Split(lang, "-")[0])
+	code, ok := iso639Codes[primary]
+	if !ok {
+		if len(primary) != 3 {
+			return "und"
+		}
+		code = primary
+	}
+
+	if b, ok := iso639Bibliographic[code]; ok && bibliographic {
+		return b
+	}
+	return code
+}
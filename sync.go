@@ -0,0 +1,403 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Manifest of a mirror, kept in its directory
+const syncManifestName = ".gotubedl-sync.json"
+
+// Playlist written in the mirror directory
+const syncPlaylistName = "playlist.m3u"
+
+// Directory receiving entries removed from the playlist when moved
+const syncRemovedDir = "removed"
+
+// Video of a mirror, filenames are relative to the mirror directory
+type syncEntry struct {
+	VideoId  string   `json:"video_id"`
+	Title    string   `json:"title"`
+	Filename string   `json:"filename"`
+	Files    []string `json:"files,omitempty"` // Video and sidecar files, the only ones renamed or removed
+}
+
+// Content of a mirror
+type syncManifest struct {
+	PlaylistId string      `json:"playlist_id"`
+	Title      string      `json:"title"`
+	Entries    []syncEntry `json:"entries"` // In playlist order
+}
+
+// Load the manifest of a mirror, missing manifests are empty
+func loadSyncManifest(dir string) (*syncManifest, error) {
+	manifest := &syncManifest{}
+	raw, err := ioutil.ReadFile(filepath.Join(dir, syncManifestName))
+	if os.IsNotExist(err) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, manifest); err != nil {
+		return nil, fmt.Errorf("%s: %v", syncManifestName, err)
+	}
+	return manifest, nil
+}
+
+func (m *syncManifest) Save(dir string) error {
+	return writeFileAtomic(filepath.Join(dir, syncManifestName), func(f *os.File) error {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "\t")
+		return enc.Encode(m)
+	})
+}
+
+// Index of an entry by video id
+func (m *syncManifest) find(videoId string) int {
+	for i, entry := range m.Entries {
+		if entry.VideoId == videoId {
+			return i
+		}
+	}
+	return -1
+}
+
+// Write an extended M3U playlist of the entries
+func writeM3U(w io.Writer, entries []syncEntry) error {
+	if _, err := fmt.Fprintln(w, "#EXTM3U"); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		_, err := fmt.Fprintf(w, "#EXTINF:-1,%s\n%s\n", entry.Title, filepath.ToSlash(entry.Filename))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Files of an entry, only the video for manifests written before files were recorded
+func (e syncEntry) files() []string {
+	if len(e.Files) == 0 {
+		return []string{e.Filename}
+	}
+	return e.Files
+}
+
+// Files of the mirror, relative to it, other than the ones kept by sync and downloads in progress
+func mirrorFiles(dir string) (map[string]bool, error) {
+
+	archive, _ := filepath.Abs(opts.DownloadArchive)
+	files := map[string]bool{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if rel == syncRemovedDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		switch {
+		case rel == syncManifestName, rel == syncPlaylistName:
+		case strings.HasSuffix(rel, ".part"), strings.HasSuffix(rel, ".tmp"):
+		default:
+			if abs, _ := filepath.Abs(path); opts.DownloadArchive == "" || abs != archive {
+				files[rel] = true
+			}
+		}
+		return nil
+	})
+	return files, err
+}
+
+// Files written by a download: the video and the new files of the mirror, like thumbnails and subtitles
+// Files of a previous download of the entry are kept when they still exist
+func downloadedFiles(filename string, before map[string]bool, after map[string]bool, previous []string) []string {
+
+	files := []string{filename}
+	seen := map[string]bool{filename: true}
+	for _, file := range previous {
+		if after[file] && !seen[file] {
+			seen[file] = true
+			files = append(files, file)
+		}
+	}
+	var added []string
+	for file := range after {
+		if !before[file] && !seen[file] {
+			added = append(added, file)
+		}
+	}
+	sort.Strings(added)
+	return append(files, added...)
+}
+
+// Rename the files of an entry after its title changed
+// Only the part of the filenames made of the title changes, directories are left as is
+func renameEntry(dir string, entry *syncEntry, title string) error {
+
+	oldTitle, newTitle := sanitizeFilename(entry.Title), sanitizeFilename(title)
+	if oldTitle == "" || !strings.Contains(filepath.Base(entry.Filename), oldTitle) {
+		entry.Title = title
+		return nil
+	}
+
+	rename := func(file string) string {
+		return filepath.Join(filepath.Dir(file), strings.Replace(filepath.Base(file), oldTitle, newTitle, 1))
+	}
+
+	// Files already renamed are renamed back on errors so the entry still matches them
+	var files, done []string
+	undo := func() {
+		for i := len(done) - 1; i >= 0; i-- {
+			os.Rename(filepath.Join(dir, rename(done[i])), filepath.Join(dir, done[i]))
+		}
+	}
+	for _, file := range entry.files() {
+		renamed := rename(file)
+		if renamed != file {
+			if _, err := os.Stat(filepath.Join(dir, renamed)); err == nil {
+				undo()
+				return fmt.Errorf("can't rename %s, %s already exists", file, renamed)
+			}
+			if err := os.Rename(filepath.Join(dir, file), filepath.Join(dir, renamed)); err != nil {
+				undo()
+				return err
+			}
+			done = append(done, file)
+		}
+		files = append(files, renamed)
+	}
+
+	if len(entry.Files) > 0 {
+		entry.Files = files
+	}
+	entry.Filename = rename(entry.Filename)
+	entry.Title = title
+	return nil
+}
+
+// Delete the files of an entry or move them to the removed directory
+// Files already gone are skipped
+func removeEntry(dir string, entry syncEntry, mode string) error {
+
+	for _, file := range entry.files() {
+		path := filepath.Join(dir, file)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			continue
+		}
+		var err error
+		if mode == "delete" {
+			err = os.Remove(path)
+		} else {
+			dest := filepath.Join(dir, syncRemovedDir, file)
+			if err = os.MkdirAll(filepath.Dir(dest), 0755); err == nil {
+				err = os.Rename(path, dest)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Match channel urls, their uploads are a playlist
+var regChannelId = regexp.MustCompile(`youtube\.com/channel/UC([0-9A-Za-z_-]{22})`)
+
+// Playlist id of a playlist or channel url
+func syncPlaylistId(syncUrl string) string {
+	if match := regChannelId.FindStringSubmatch(syncUrl); match != nil {
+		return "UU" + match[1]
+	}
+	return ExtractPlaylistId(syncUrl)
+}
+
+// Counts of a sync
+type syncResult struct {
+	Added, Renamed, Removed, Failed int
+}
+
+// Mirror a playlist or channel in dir
+// New videos are downloaded, renamed ones follow their title and removed ones are handled by the sync-removed option
+func Sync(ctx context.Context, syncUrl string, dir string, out io.Writer) (syncResult, error) {
+
+	var result syncResult
+	playlistId := syncPlaylistId(syncUrl)
+	if playlistId == "" {
+		return result, fmt.Errorf("no playlist or channel found in %s", syncUrl)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return result, err
+	}
+	manifest, err := loadSyncManifest(dir)
+	if err != nil {
+		return result, err
+	}
+	if manifest.PlaylistId != "" && manifest.PlaylistId != playlistId {
+		return result, fmt.Errorf("%s mirrors playlist %s, not %s", dir, manifest.PlaylistId, playlistId)
+	}
+
+	playlist, err := GetPlaylistInfo(ctx, playlistId)
+	if err != nil {
+		return result, err
+	}
+	manifest.PlaylistId = playlistId
+	if playlist.Title != "" {
+		manifest.Title = playlist.Title
+	}
+
+	// Videos gone from the playlist, only known when it was fully listed
+	if !playlist.Complete {
+		fmt.Fprintln(out, "Playlist may be incomplete, removed videos are left as is")
+	}
+	remote := map[string]bool{}
+	for _, video := range playlist.Videos {
+		remote[video.VideoId] = true
+	}
+	var kept []syncEntry
+	for _, entry := range manifest.Entries {
+		if remote[entry.VideoId] || !playlist.Complete {
+			kept = append(kept, entry)
+			continue
+		}
+		if opts.SyncRemoved == "keep" {
+			continue
+		}
+		if err := removeEntry(dir, entry, opts.SyncRemoved); err != nil {
+			return result, err
+		}
+		fmt.Fprintf(out, "Removed %s (%s)\n", entry.Filename, opts.SyncRemoved)
+		result.Removed++
+	}
+	manifest.Entries = kept
+
+	var entries []syncEntry
+	for i, video := range playlist.Videos {
+
+		if ctx.Err() != nil {
+			break
+		}
+
+		// Known videos are renamed when their title changed
+		if j := manifest.find(video.VideoId); j >= 0 {
+			entry := manifest.Entries[j]
+			if _, err := os.Stat(filepath.Join(dir, entry.Filename)); err == nil {
+				if video.Title != "" && video.Title != entry.Title {
+					if err := renameEntry(dir, &entry, video.Title); err != nil {
+						return result, err
+					}
+					fmt.Fprintf(out, "Renamed %s to %s\n", manifest.Entries[j].Filename, entry.Filename)
+					manifest.Entries[j] = entry
+					result.Renamed++
+				}
+				entries = append(entries, entry)
+				continue
+			}
+		}
+
+		// New or missing videos, files of the mirror are listed to know the ones written
+		before, err := mirrorFiles(dir)
+		if err != nil {
+			return result, err
+		}
+		item := &PlaylistItem{PlaylistId: playlistId, PlaylistTitle: playlist.Title, PlaylistIndex: i + 1, PlaylistCount: len(playlist.Videos)}
+		filename, err := download(ctx, video.WebpageUrl(), item, dir, out)
+		if err != nil {
+			fmt.Fprintf(out, "%s: %v\n", video.WebpageUrl(), err)
+			result.Failed++
+			continue
+		}
+		if filename == "" || opts.Simulate || printing() {
+			continue
+		}
+		if rel, err := filepath.Rel(dir, filename); err == nil {
+			filename = rel
+		}
+
+		title := video.Title
+		if title == "" {
+			title = strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+		}
+		after, err := mirrorFiles(dir)
+		if err != nil {
+			return result, err
+		}
+		entry := syncEntry{VideoId: video.VideoId, Title: title, Filename: filename}
+		if j := manifest.find(video.VideoId); j >= 0 {
+			entry.Files = downloadedFiles(filename, before, after, manifest.Entries[j].Files)
+			manifest.Entries[j] = entry
+		} else {
+			entry.Files = downloadedFiles(filename, before, after, nil)
+			manifest.Entries = append(manifest.Entries, entry)
+		}
+		entries = append(entries, entry)
+		result.Added++
+
+		// Saved after each download so interrupted syncs resume
+		if err := manifest.Save(dir); err != nil {
+			return result, err
+		}
+	}
+
+	// Interrupted syncs keep entries not reached yet, incomplete listings the ones not listed
+	if ctx.Err() == nil {
+		if !playlist.Complete {
+			for _, entry := range manifest.Entries {
+				if !remote[entry.VideoId] {
+					entries = append(entries, entry)
+				}
+			}
+		}
+		manifest.Entries = entries
+	}
+	if err := manifest.Save(dir); err != nil {
+		return result, err
+	}
+
+	err = writeFileAtomic(filepath.Join(dir, syncPlaylistName), func(f *os.File) error {
+		return writeM3U(f, manifest.Entries)
+	})
+	return result, err
+}
+
+// Run the sync subcommand: sync URL DIR
+// Return the exit code
+func runSync(ctx context.Context, args []string, out io.Writer) int {
+
+	if len(args) != 2 {
+		fmt.Fprintln(os.Stderr, "Usage: gotubedl [OPTIONS] sync URL DIR")
+		return 2
+	}
+
+	result, err := Sync(ctx, args[0], args[1], out)
+	fmt.Fprintf(out, "Sync: %d added, %d renamed, %d removed, %d failed\n",
+		result.Added, result.Renamed, result.Removed, result.Failed)
+
+	switch {
+	case err != nil:
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	case ctx.Err() != nil:
+		return 130
+	case result.Failed > 0:
+		return 1
+	}
+	return 0
+}
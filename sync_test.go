@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func listFiles(dir string) []string {
+	var files []string
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			rel, _ := filepath.Rel(dir, path)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files
+}
+
+func TestSyncEntries(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gotubedl-sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Mkdir(filepath.Join(dir, "Old title"), 0755)
+	names := []string{"Old title/Old title.mp4", "Old title/Old title.en.vtt", "Old title/Old title.jpg", "Old title/Old titles.mp4",
+		"Live.webm", "Live.jpg", "Live. Part 2.mp4", "Live. Part 2.jpg"}
+	for _, name := range names {
+		if err := ioutil.WriteFile(filepath.Join(dir, filepath.FromSlash(name)), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Recorded files are renamed, the directory named after the title is left as is
+	old := func(name string) string { return filepath.Join("Old title", name) }
+	entry := syncEntry{VideoId: videoId, Title: "Old title", Filename: old("Old title.mp4"),
+		Files: []string{old("Old title.mp4"), old("Old title.en.vtt"), old("Old title.jpg")}}
+	if err := renameEntry(dir, &entry, "New/title"); err != nil {
+		t.Fatal(err)
+	}
+	expectedFiles := []string{old("New_title.mp4"), old("New_title.en.vtt"), old("New_title.jpg")}
+	if entry.Filename != old("New_title.mp4") || entry.Title != "New/title" || !reflect.DeepEqual(entry.Files, expectedFiles) {
+		t.Errorf("unexpected entry %+v", entry)
+	}
+
+	// Only recorded files are moved, other videos sharing the start of the name stay
+	if err := removeEntry(dir, syncEntry{VideoId: "9bZkp7q19f0", Title: "Live", Filename: "Live.webm", Files: []string{"Live.webm", "Live.jpg"}}, "move"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"Live. Part 2.jpg", "Live. Part 2.mp4", "Old title/New_title.en.vtt", "Old title/New_title.jpg", "Old title/New_title.mp4",
+		"Old title/Old titles.mp4", "removed/Live.jpg", "removed/Live.webm"}
+	if files := listFiles(dir); !reflect.DeepEqual(files, expected) {
+		t.Errorf("expected %v, got %v", expected, files)
+	}
+
+	// Manifests without files only touch the video
+	if err := removeEntry(dir, syncEntry{VideoId: "kJQP7kiw5Fk", Title: "Live. Part 2", Filename: "Live. Part 2.mp4"}, "delete"); err != nil {
+		t.Fatal(err)
+	}
+	if files := listFiles(dir); files[0] != "Live. Part 2.jpg" || files[1] != "Old title/New_title.en.vtt" {
+		t.Errorf("expected only the video to be deleted, got %v", files)
+	}
+
+	// A failed rename leaves the files and the entry as they were
+	ioutil.WriteFile(filepath.Join(dir, old("Other.jpg")), nil, 0644)
+	conflicting := entry
+	if err := renameEntry(dir, &conflicting, "Other"); err == nil {
+		t.Errorf("expected error renaming over an existing file")
+	}
+	if !reflect.DeepEqual(conflicting, entry) {
+		t.Errorf("expected unchanged entry, got %+v", conflicting)
+	}
+	if _, err := os.Stat(filepath.Join(dir, old("New_title.mp4"))); err != nil {
+		t.Errorf("expected renamed video to be renamed back: %v", err)
+	}
+
+	// Manifest survives a round trip
+	manifest := &syncManifest{PlaylistId: "PL1", Title: "Mirror", Entries: []syncEntry{entry}}
+	if err := manifest.Save(dir); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadSyncManifest(dir)
+	if err != nil || !reflect.DeepEqual(loaded, manifest) {
+		t.Errorf("expected %+v, got %+v %v", manifest, loaded, err)
+	}
+}
+
+func TestWriteM3U(t *testing.T) {
+
+	var buf bytes.Buffer
+	entries := []syncEntry{
+		{VideoId: videoId, Title: "First", Filename: "First.mp4"},
+		{VideoId: "9bZkp7q19f0", Title: "Second", Filename: filepath.Join("sub", "Second.webm")},
+	}
+	if err := writeM3U(&buf, entries); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "#EXTM3U\n#EXTINF:-1,First\nFirst.mp4\n#EXTINF:-1,Second\nsub/Second.webm\n"
+	if buf.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, buf.String())
+	}
+}
+
+func TestSyncPlaylistId(t *testing.T) {
+
+	tests := map[string]string{
+		"https://www.youtube.com/playlist?list=PLFgquLnL59alCl_2TQvOiD5Vgm1hCaGSI": "PLFgquLnL59alCl_2TQvOiD5Vgm1hCaGSI",
+		"https://www.youtube.com/channel/UCuAXFkgsw1L7xaCfnd5JJOw/videos":          "UUuAXFkgsw1L7xaCfnd5JJOw",
+		"https://www.youtube.com/watch?v=" + videoId:                               "",
+	}
+	for url, expected := range tests {
+		if id := syncPlaylistId(url); id != expected {
+			t.Errorf("%s: expected %q, got %q", url, expected, id)
+		}
+	}
+}
+
+func TestDownloadedFiles(t *testing.T) {
+
+	before := map[string]bool{"Other.mp4": true, "Video.jpg": true}
+	after := map[string]bool{"Other.mp4": true, "Video.jpg": true, "Video.mp4": true, "Video.en.vtt": true, "Video.info.json": true}
+
+	files := downloadedFiles("Video.mp4", before, after, []string{"Video.jpg", "Video.old.vtt"})
+	expected := []string{"Video.mp4", "Video.jpg", "Video.en.vtt", "Video.info.json"}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("expected %v, got %v", expected, files)
+	}
+}
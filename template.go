@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Default output template, same as youtube-dl
+const DefaultOutputTemplate = "%(title)s.%(ext)s"
+
+// Match %(field)s and %(field)d, numbers can be zero padded like %(field)03d
+var regTemplateField = regexp.MustCompile(`%\((\w+)\)(0\d+)?([sd])`)
+
+// Fields usable in the output template
+func templateFields(video *Video, format Format) map[string]string {
+	fields := map[string]string{
+		"id":          video.VideoId,
+		"title":       video.Title,
+		"author":      video.Author,
+		"duration":    video.Duration,
+		"view_count":  strconv.Itoa(video.ViewCount),
+		"format_id":   strconv.Itoa(format.FormatId),
+		"ext":         format.Ext,
+		"width":       strconv.Itoa(format.Width),
+		"height":      strconv.Itoa(format.Height),
+		"resolution":  format.Resolution,
+		"fps":         strconv.Itoa(format.Fps),
+		"vcodec":      format.Vcodec,
+		"acodec":      format.Acodec,
+		"format_note": format.Format_note,
+	}
+	addPlaylistFields(fields, video)
+	return fields
+}
+
+// Fields of the playlist a video was downloaded from, NA otherwise
+func addPlaylistFields(fields map[string]string, video *Video) {
+	if item := video.PlaylistItem; item != nil {
+		fields["playlist_id"] = item.PlaylistId
+		fields["playlist_title"] = item.PlaylistTitle
+		fields["playlist_index"] = strconv.Itoa(item.PlaylistIndex)
+		fields["playlist_count"] = strconv.Itoa(item.PlaylistCount)
+	}
+}
+
+// Build a filename from an output template
+// Unknown fields are replaced by NA
+func BuildFilename(tmpl string, video *Video, format Format) string {
+	return fillTemplate(tmpl, templateFields(video, format))
+}
+
+// Build the filename of a chapter, chapter fields are added to the video ones
+func BuildChapterFilename(tmpl string, video *Video, format Format, chapter Chapter, number int) string {
+	fields := templateFields(video, format)
+	fields["chapter"] = chapter.Title
+	fields["chapter_number"] = strconv.Itoa(number)
+	fields["chapter_start"] = strconv.Itoa(int(chapter.StartTime))
+	fields["chapter_end"] = strconv.Itoa(int(chapter.EndTime))
+	return fillTemplate(tmpl, fields)
+}
+
+// Build a line printed by the print option
+// A single field name prints that field, url and filename are added to the video fields
+func PrintTemplate(tmpl string, video *Video, format Format, filename string) string {
+	if !strings.Contains(tmpl, "%(") {
+		tmpl = "%(" + tmpl + ")s"
+	}
+	fields := templateFields(video, format)
+	fields["url"] = format.Url
+	fields["filename"] = filename
+	return expandTemplate(tmpl, fields, func(value string) string { return value })
+}
+
+// Replace template fields with their values, made safe for filenames
+func fillTemplate(tmpl string, fields map[string]string) string {
+	return expandTemplate(tmpl, fields, sanitizeFilename)
+}
+
+// Replace template fields with their values, passed through escape
+func expandTemplate(tmpl string, fields map[string]string, escape func(string) string) string {
+	return regTemplateField.ReplaceAllStringFunc(tmpl, func(match string) string {
+		submatch := regTemplateField.FindStringSubmatch(match)
+		value, ok := fields[submatch[1]]
+		if !ok || value == "" {
+			return "NA"
+		}
+		if n, err := strconv.Atoi(value); err == nil && submatch[3] == "d" && submatch[2] != "" {
+			value = fmt.Sprintf("%"+submatch[2]+"d", n)
+		}
+		return escape(value)
+	})
+}
+
+// Replace chars that can't be part of a filename
+func sanitizeFilename(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', 0:
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// Replace extension of a filename, ext is given without dot
+func replaceExt(filename string, ext string) string {
+	return strings.TrimSuffix(filename, filepath.Ext(filename)) + "." + ext
+}
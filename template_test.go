@@ -0,0 +1,53 @@
+package main
+
+import "testing"
+
+func TestBuildFilename(t *testing.T) {
+
+	video := &Video{VideoId: videoId, Title: "AC/DC - Thunderstruck"}
+	format := Format{FormatId: 22, Ext: "mp4"}
+
+	// Default template
+	if name := BuildFilename(DefaultOutputTemplate, video, format); name != "AC_DC - Thunderstruck.mp4" {
+		t.Errorf("bad filename %s", name)
+	}
+
+	// Unknown fields
+	if name := BuildFilename("%(id)s-%(nope)s.%(ext)s", video, format); name != videoId+"-NA.mp4" {
+		t.Errorf("bad filename %s", name)
+	}
+
+	// Playlist fields
+	playlistVideo := *video
+	playlistVideo.PlaylistItem = &PlaylistItem{PlaylistTitle: "Rock", PlaylistIndex: 4, PlaylistCount: 12}
+	if name := BuildFilename("%(playlist_title)s/%(playlist_index)02d of %(playlist_count)d.%(ext)s", &playlistVideo, format); name != "Rock/04 of 12.mp4" {
+		t.Errorf("bad filename %s", name)
+	}
+	if name := BuildFilename("%(playlist_index)s.%(ext)s", video, format); name != "NA.mp4" {
+		t.Errorf("bad filename %s", name)
+	}
+
+	// Chapter fields and zero padding
+	chapter := Chapter{Title: "Intro"}
+	if name := BuildChapterFilename(DefaultChapterTemplate, video, format, chapter, 7); name != "AC_DC - Thunderstruck - 007 Intro.mp4" {
+		t.Errorf("bad filename %s", name)
+	}
+}
+
+func TestPrintTemplate(t *testing.T) {
+
+	video := &Video{VideoId: videoId, Title: "AC/DC - Thunderstruck"}
+	format := Format{FormatId: 22, Ext: "mp4", Url: "https://example.com/videoplayback?itag=22"}
+
+	tests := map[string]string{
+		"title":                         "AC/DC - Thunderstruck",
+		"url":                           format.Url,
+		"%(id)s %(format_id)s":          videoId + " 22",
+		"%(filename)s (%(duration)s s)": "AC_DC - Thunderstruck.mp4 (NA s)",
+	}
+	for tmpl, expected := range tests {
+		if line := PrintTemplate(tmpl, video, format, "AC_DC - Thunderstruck.mp4"); line != expected {
+			t.Errorf("%s: expected %q, got %q", tmpl, expected, line)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	_ "golang.org/x/image/webp"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+)
+
+// Thumbnail
+type Thumbnail struct {
+	Id         string `json:"id"`
+	Url        string `json:"url"`
+	Ext        string `json:"ext"`
+	Width      int    `json:"width"`
+	Height     int    `json:"height"`
+	Preference int    `json:"preference"`
+}
+
+// Thumbnails generated by youtube for every video, biggest first
+var thumbnailSizes = []struct {
+	Name   string
+	Width  int
+	Height int
+}{
+	{"maxresdefault", 1280, 720},
+	{"sddefault", 640, 480},
+	{"hqdefault", 480, 360},
+	{"mqdefault", 320, 180},
+	{"default", 120, 90},
+}
+
+// List thumbnails of a video, best first
+// Webp is preferred over jpg for the same size as it is usually lighter
+func GetThumbnails(videoId string) []Thumbnail {
+	var thumbnails []Thumbnail
+	for i, size := range thumbnailSizes {
+		preference := (len(thumbnailSizes) - i) * 2
+		thumbnails = append(thumbnails,
+			Thumbnail{
+				Id:         size.Name + "_webp",
+				Url:        "https://i.ytimg.com/vi_webp/" + videoId + "/" + size.Name + ".webp",
+				Ext:        "webp",
+				Width:      size.Width,
+				Height:     size.Height,
+				Preference: preference + 1,
+			},
+			Thumbnail{
+				Id:         size.Name,
+				Url:        "https://i.ytimg.com/vi/" + videoId + "/" + size.Name + ".jpg",
+				Ext:        "jpg",
+				Width:      size.Width,
+				Height:     size.Height,
+				Preference: preference,
+			})
+	}
+	return thumbnails
+}
+
+// Convert a thumbnail to jpg or png
+func ConvertThumbnail(data []byte, ext string) ([]byte, error) {
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	switch ext {
+	case "jpg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95})
+	case "png":
+		err = png.Encode(&buf, img)
+	default:
+		return nil, fmt.Errorf("unsupported thumbnail format %s", ext)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Download thumbnails of a video next to its file
+// Only the best available one is written unless all thumbnails are requested
+// Return the list of written files
+func writeThumbnails(ctx context.Context, video *Video, filename string, out io.Writer) ([]string, error) {
+
+	var files []string
+
+	for _, thumbnail := range video.Thumbnails {
+
+		// Not every size exists, try the next one
+		data, err := downloadPage(ctx, thumbnail.Url)
+		if err != nil {
+			if opts.Verbose {
+				fmt.Fprintln(out, "Thumbnail", thumbnail.Id, "not available:", err)
+			}
+			continue
+		}
+
+		raw := []byte(data)
+		ext := thumbnail.Ext
+
+		// Convert thumbnail if needed
+		if opts.ConvertThumbnails != "" && opts.ConvertThumbnails != ext {
+			raw, err = ConvertThumbnail(raw, opts.ConvertThumbnails)
+			if err != nil {
+				return files, err
+			}
+			ext = opts.ConvertThumbnails
+		}
+
+		// Keep thumbnails apart when writing all of them
+		dest := replaceExt(filename, ext)
+		if opts.WriteAllThumbnails {
+			dest = replaceExt(filename, thumbnail.Id+"."+ext)
+		}
+
+		if err := ioutil.WriteFile(dest, raw, 0644); err != nil {
+			return files, err
+		}
+
+		if !opts.Json {
+			fmt.Fprintln(out, "Thumbnail saved to", dest)
+		}
+		files = append(files, dest)
+
+		if !opts.WriteAllThumbnails {
+			break
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no thumbnail available for %s", video.VideoId)
+	}
+
+	return files, nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Channel or playlist followed by the watch subcommand
+// Videos go to Dir, named after the channel when empty
+type Subscription struct {
+	Url string
+	Dir string
+}
+
+// Read subscriptions, one "url [directory]" per line, like batch files
+func readSubscriptions(filename string) ([]Subscription, error) {
+	var subscriptions []Subscription
+	err := readBatchFile(filename, func(line string, _ int) {
+		fields := strings.Fields(line)
+		subscriptions = append(subscriptions, Subscription{
+			Url: fields[0],
+			Dir: strings.TrimSpace(strings.TrimPrefix(line, fields[0])),
+		})
+	})
+	return subscriptions, err
+}
+
+// Match user urls, channels and playlists are matched by sync
+var regUserName = regexp.MustCompile(`youtube\.com/user/([0-9A-Za-z_-]+)`)
+
+// Url of the feed of a channel, user or playlist
+// Feeds list the latest uploads without extracting them
+func FeedUrl(subscriptionUrl string) (string, error) {
+	const feeds = "https://www.youtube.com/feeds/videos.xml?"
+	if match := regChannelId.FindStringSubmatch(subscriptionUrl); match != nil {
+		return feeds + "channel_id=UC" + match[1], nil
+	}
+	if match := regUserName.FindStringSubmatch(subscriptionUrl); match != nil {
+		return feeds + "user=" + match[1], nil
+	}
+	if playlistId := ExtractPlaylistId(subscriptionUrl); playlistId != "" {
+		return feeds + "playlist_id=" + playlistId, nil
+	}
+	return "", fmt.Errorf("no channel, user or playlist found in %s", subscriptionUrl)
+}
+
+// Atom feed of a channel or playlist, newest videos first
+type VideoFeed struct {
+	Title   string `xml:"title"`
+	Entries []struct {
+		VideoId   string `xml:"http://www.youtube.com/xml/schemas/2015 videoId"`
+		Title     string `xml:"title"`
+		Author    string `xml:"author>name"`
+		Published string `xml:"published"`
+	} `xml:"entry"`
+}
+
+func ParseFeed(data []byte) (*VideoFeed, error) {
+	var feed VideoFeed
+	if err := xml.Unmarshal(data, &feed); err != nil {
+		return nil, err
+	}
+	return &feed, nil
+}
+
+// Check a subscription and download its new videos, oldest first
+// Return the number of downloaded videos
+func pollSubscription(ctx context.Context, subscription Subscription, root string, out io.Writer) (int, error) {
+
+	feedUrl, err := FeedUrl(subscription.Url)
+	if err != nil {
+		return 0, err
+	}
+	page, err := downloadPage(ctx, feedUrl)
+	if err != nil {
+		return 0, err
+	}
+	feed, err := ParseFeed([]byte(page))
+	if err != nil {
+		return 0, err
+	}
+
+	dir := subscription.Dir
+	if dir == "" {
+		dir = sanitizeFilename(feed.Title)
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(root, dir)
+	}
+
+	var downloaded int
+	for i := len(feed.Entries) - 1; i >= 0 && ctx.Err() == nil; i-- {
+		videoUrl := "https://www.youtube.com/watch?v=" + feed.Entries[i].VideoId
+		filename, err := download(ctx, videoUrl, nil, dir, out)
+		if err != nil {
+			fmt.Fprintf(out, "%s: %v\n", videoUrl, err)
+			continue
+		}
+		if filename != "" {
+			downloaded++
+		}
+	}
+
+	return downloaded, nil
+}
+
+// Poll subscriptions listed in a file until the context is cancelled
+// The file is read again before each check so it can be edited while watching
+// Downloaded videos are recorded in the archive, kept in root unless one is given
+func Watch(ctx context.Context, filename string, root string, interval time.Duration, out io.Writer) error {
+
+	// Stdin can't be read again
+	if filename == "-" {
+		return fmt.Errorf("subscriptions can't be read from stdin")
+	}
+	subscriptions, err := readSubscriptions(filename)
+	if err != nil {
+		return err
+	}
+
+	if opts.DownloadArchive == "" {
+		opts.DownloadArchive = filepath.Join(root, "archive.txt")
+	}
+
+	for {
+		for _, subscription := range subscriptions {
+			if ctx.Err() != nil {
+				return nil
+			}
+			downloaded, err := pollSubscription(ctx, subscription, root, out)
+			if err != nil {
+				fmt.Fprintf(out, "%s: %v\n", subscription.Url, err)
+			} else if downloaded > 0 {
+				fmt.Fprintf(out, "%s: %d new videos\n", subscription.Url, downloaded)
+			}
+		}
+
+		next := time.Now().Add(interval)
+		fmt.Fprintf(out, "Checked %d subscriptions, next check at %s\n", len(subscriptions), next.Format("15:04:05"))
+
+		t := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil
+		case <-t.C:
+		}
+
+		// File may be missing for a moment while saved, the last list is kept
+		if latest, err := readSubscriptions(filename); err != nil {
+			fmt.Fprintln(out, "Unable to read subscriptions, keeping the previous ones:", err)
+		} else {
+			subscriptions = latest
+		}
+	}
+}
+
+// Run the watch subcommand: watch FILE [DIR]
+// Return the exit code
+func runWatch(ctx context.Context, args []string, out io.Writer) int {
+
+	if len(args) < 1 || len(args) > 2 {
+		fmt.Fprintln(os.Stderr, "Usage: gotubedl [OPTIONS] watch FILE [DIR]")
+		return 2
+	}
+	interval, err := time.ParseDuration(opts.WatchInterval)
+	if err != nil || interval < time.Minute {
+		fmt.Fprintln(os.Stderr, "Watch interval must be a duration of at least a minute (e.g. 30m or 2h)")
+		return 2
+	}
+
+	root := "."
+	if len(args) == 2 {
+		root = args[1]
+	}
+
+	if err := Watch(ctx, args[0], root, interval, out); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
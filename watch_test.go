@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns:yt="http://www.youtube.com/xml/schemas/2015" xmlns:media="http://search.yahoo.com/mrss/" xmlns="http://www.w3.org/2005/Atom">
+ <title>Rick Astley</title>
+ <yt:channelId>UCuAXFkgsw1L7xaCfnd5JJOw</yt:channelId>
+ <entry>
+  <yt:videoId>9bZkp7q19f0</yt:videoId>
+  <title>Newest &amp; best</title>
+  <author><name>Rick Astley</name></author>
+  <published>2020-03-02T10:00:00+00:00</published>
+ </entry>
+ <entry>
+  <yt:videoId>dQw4w9WgXcQ</yt:videoId>
+  <title>Never Gonna Give You Up</title>
+  <author><name>Rick Astley</name></author>
+  <published>2009-10-25T06:57:33+00:00</published>
+ </entry>
+</feed>`
+
+func TestParseFeed(t *testing.T) {
+
+	feed, err := ParseFeed([]byte(testFeed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if feed.Title != "Rick Astley" || len(feed.Entries) != 2 {
+		t.Fatalf("unexpected feed %+v", feed)
+	}
+	entry := feed.Entries[0]
+	if entry.VideoId != "9bZkp7q19f0" || entry.Title != "Newest & best" || entry.Author != "Rick Astley" {
+		t.Errorf("unexpected entry %+v", entry)
+	}
+}
+
+func TestFeedUrl(t *testing.T) {
+
+	tests := map[string]string{
+		"https://www.youtube.com/channel/UCuAXFkgsw1L7xaCfnd5JJOw":                 "https://www.youtube.com/feeds/videos.xml?channel_id=UCuAXFkgsw1L7xaCfnd5JJOw",
+		"https://www.youtube.com/user/RickAstleyVEVO/videos":                       "https://www.youtube.com/feeds/videos.xml?user=RickAstleyVEVO",
+		"https://www.youtube.com/playlist?list=PLFgquLnL59alCl_2TQvOiD5Vgm1hCaGSI": "https://www.youtube.com/feeds/videos.xml?playlist_id=PLFgquLnL59alCl_2TQvOiD5Vgm1hCaGSI",
+	}
+	for url, expected := range tests {
+		if feedUrl, err := FeedUrl(url); err != nil || feedUrl != expected {
+			t.Errorf("%s: expected %s, got %s %v", url, expected, feedUrl, err)
+		}
+	}
+
+	if _, err := FeedUrl("https://www.youtube.com/watch?v=" + videoId); err == nil {
+		t.Errorf("expected error for video url")
+	}
+}
+
+func TestReadSubscriptions(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "gotubedl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "subscriptions.txt")
+	content := "# Music\nhttps://www.youtube.com/channel/UCuAXFkgsw1L7xaCfnd5JJOw\n\nhttps://www.youtube.com/playlist?list=PL1  My favorites \n"
+	if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	subscriptions, err := readSubscriptions(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Subscription{
+		{Url: "https://www.youtube.com/channel/UCuAXFkgsw1L7xaCfnd5JJOw"},
+		{Url: "https://www.youtube.com/playlist?list=PL1", Dir: "My favorites"},
+	}
+	if !reflect.DeepEqual(subscriptions, expected) {
+		t.Errorf("expected %+v, got %+v", expected, subscriptions)
+	}
+}
+
+func TestWatchStdin(t *testing.T) {
+	if err := Watch(context.Background(), "-", ".", time.Hour, ioutil.Discard); err == nil {
+		t.Errorf("expected error for subscriptions read from stdin")
+	}
+}
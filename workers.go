@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// Console output of concurrent jobs, printed in the order jobs were added
+// The oldest unfinished job prints live, others are buffered until their turn
+type orderedOutput struct {
+	mu   sync.Mutex
+	w    io.Writer
+	next int // Index of the job printing live
+	jobs map[int]*jobOutput
+}
+
+// Output of a single job
+type jobOutput struct {
+	o     *orderedOutput
+	index int
+	buf   bytes.Buffer
+	done  bool
+}
+
+func newOrderedOutput(w io.Writer) *orderedOutput {
+	return &orderedOutput{w: w, jobs: make(map[int]*jobOutput)}
+}
+
+// Create the output of the next job
+func (o *orderedOutput) Job(index int) *jobOutput {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	job := &jobOutput{o: o, index: index}
+	o.jobs[index] = job
+	return job
+}
+
+func (j *jobOutput) Write(p []byte) (int, error) {
+	j.o.mu.Lock()
+	defer j.o.mu.Unlock()
+	if j.index == j.o.next {
+		return j.o.w.Write(p)
+	}
+	return j.buf.Write(p)
+}
+
+// Mark the job as finished, following jobs are printed
+func (j *jobOutput) Close() error {
+	o := j.o
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	j.done = true
+	for {
+		head, ok := o.jobs[o.next]
+		if !ok {
+			return nil
+		}
+		if _, err := head.buf.WriteTo(o.w); err != nil {
+			return err
+		}
+		if !head.done {
+			return nil
+		}
+		delete(o.jobs, o.next)
+		o.next++
+	}
+}
+
+// Video url to process
+type job struct {
+	index    int
+	url      string
+	source   string        // Where the url comes from, used in errors
+	playlist *PlaylistItem // Set for videos of a playlist
+	out      *jobOutput
+	err      error
+}
+
+// Pool of workers downloading videos concurrently
+// Once the context is cancelled, running jobs stop and queued ones are skipped
+type workerPool struct {
+	ctx         context.Context
+	jobs        chan *job
+	output      *orderedOutput
+	wg          sync.WaitGroup
+	mu          sync.Mutex
+	count       int
+	failures    []*job
+	interrupted int // Jobs stopped or skipped by cancellation
+}
+
+// Start workers, their output is written to w
+func newWorkerPool(ctx context.Context, workers int, w io.Writer) *workerPool {
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	p := &workerPool{ctx: ctx, jobs: make(chan *job), output: newOrderedOutput(w)}
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+
+	return p
+}
+
+func (p *workerPool) work() {
+	for job := range p.jobs {
+		if job.err = p.ctx.Err(); job.err == nil {
+			_, job.err = download(p.ctx, job.url, job.playlist, "", job.out)
+		}
+
+		p.mu.Lock()
+		switch {
+		case job.err == nil:
+		case p.ctx.Err() != nil:
+			p.interrupted++
+		default:
+			fmt.Fprintf(job.out, "%s: %v\n", job.source, job.err)
+			p.failures = append(p.failures, job)
+		}
+		p.mu.Unlock()
+
+		job.out.Close()
+		p.wg.Done()
+	}
+}
+
+// Queue a video url, block until a worker is available
+func (p *workerPool) Add(url string, source string, playlist *PlaylistItem) {
+	p.wg.Add(1)
+	job := &job{index: p.count, url: url, source: source, playlist: playlist, out: p.output.Job(p.count)}
+	p.count++
+	p.jobs <- job
+}
+
+// Record a url that failed before any video could be queued
+func (p *workerPool) Fail(url string, source string, err error) {
+	job := &job{index: p.count, url: url, source: source, err: err, out: p.output.Job(p.count)}
+	p.count++
+
+	p.mu.Lock()
+	if p.ctx.Err() != nil {
+		p.interrupted++
+	} else {
+		fmt.Fprintf(job.out, "%s: %v\n", job.source, job.err)
+		p.failures = append(p.failures, job)
+	}
+	p.mu.Unlock()
+
+	job.out.Close()
+}
+
+// Wait for all jobs, return the failed ones in order
+func (p *workerPool) Wait() []*job {
+	close(p.jobs)
+	p.wg.Wait()
+	sort.Slice(p.failures, func(i, j int) bool {
+		return p.failures[i].index < p.failures[j].index
+	})
+	return p.failures
+}
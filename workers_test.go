@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOrderedOutput(t *testing.T) {
+
+	var buf bytes.Buffer
+	output := newOrderedOutput(&buf)
+	jobs := []*jobOutput{output.Job(0), output.Job(1), output.Job(2)}
+
+	// Later jobs finish first
+	var wg sync.WaitGroup
+	for i, job := range jobs {
+		wg.Add(1)
+		go func(i int, job *jobOutput) {
+			defer wg.Done()
+			time.Sleep(time.Duration(len(jobs)-i) * 10 * time.Millisecond)
+			fmt.Fprintf(job, "job %d start\n", i)
+			fmt.Fprintf(job, "job %d end\n", i)
+			job.Close()
+		}(i, job)
+	}
+	wg.Wait()
+
+	expected := "job 0 start\njob 0 end\njob 1 start\njob 1 end\njob 2 start\njob 2 end\n"
+	if buf.String() != expected {
+		t.Errorf("expected %q, got %q", expected, buf.String())
+	}
+}
@@ -1,65 +1,343 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
 	"net/url"
 	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 type Playlist struct {
+	Id     string  `json:"id"`
 	Title  string  `json:"title"`
 	Videos []Video `json:"videos"`
+
+	// False when some videos may be missing, continuations couldn't all be fetched
+	Complete bool `json:"complete"`
+}
+
+// Position of a video in the playlist it was downloaded from
+type PlaylistItem struct {
+	PlaylistId    string `json:"playlist_id"`
+	PlaylistTitle string `json:"playlist_title"`
+	PlaylistIndex int    `json:"playlist_index"` // Starts at 1
+	PlaylistCount int    `json:"playlist_count"`
 }
 
 type Video struct {
-	VideoId   string  `json:"video_id"`
-	Title     string  `json:"title"`
-	Author    string  `json:"title"`
-	Duration  string  `json:"duration"`
-	Formats   Formats `json:"formats"`
-	ViewCount int     `json:"view_count"`
+	VideoId     string      `json:"video_id"`
+	Title       string      `json:"title"`
+	Author      string      `json:"author"`
+	Description string      `json:"description"`
+	UploadDate  string      `json:"upload_date"` // YYYYMMDD
+	Duration    string      `json:"duration"`
+	Formats     Formats     `json:"formats"`
+	ViewCount   int         `json:"view_count"`
+	Thumbnails  []Thumbnail `json:"thumbnails"`
+	Subtitles   []Subtitle  `json:"subtitles"`
+	Chapters    []Chapter   `json:"chapters"`
+
+	// Set when downloaded from a playlist
+	*PlaylistItem
+}
+
+// Subset of the player_response JSON found in video info
+type PlayerResponse struct {
+	VideoDetails struct {
+		ShortDescription string `json:"shortDescription"`
+		ViewCount        string `json:"viewCount"`
+	} `json:"videoDetails"`
+	Microformat struct {
+		PlayerMicroformatRenderer struct {
+			UploadDate  string `json:"uploadDate"`
+			PublishDate string `json:"publishDate"`
+		} `json:"playerMicroformatRenderer"`
+	} `json:"microformat"`
+	Captions struct {
+		PlayerCaptionsTracklistRenderer struct {
+			CaptionTracks []struct {
+				BaseUrl string `json:"baseUrl"`
+				Name    struct {
+					SimpleText string `json:"simpleText"`
+					Runs       []struct {
+						Text string `json:"text"`
+					} `json:"runs"`
+				} `json:"name"`
+				LanguageCode string `json:"languageCode"`
+				Kind         string `json:"kind"`
+			} `json:"captionTracks"`
+		} `json:"playerCaptionsTracklistRenderer"`
+	} `json:"captions"`
+}
+
+// Url of the video watch page
+func (v *Video) WebpageUrl() string {
+	return "https://www.youtube.com/watch?v=" + v.VideoId
 }
 
-// Extract video id from youtube's url
+// Extract video id from youtube's url, empty when there is none
 func ExtractVideoId(videoUrl string) string {
 	r := regexp.MustCompile(`(?:youtube\.com\/(?:[^\/]+\/.+\/|(?:v|e(?:mbed)?)\/|.*[?&]v=)|youtu\.be\/)([^"&?\/ ]{11})`)
 	match := r.FindStringSubmatch(videoUrl)
+	if match == nil {
+		return ""
+	}
 	return match[1]
 }
 
 // Get playlist info from youtube
-func GetPlaylistInfo(playlistId string) (playlist Playlist) {
+func GetPlaylistInfo(ctx context.Context, playlistId string) (Playlist, error) {
 
 	playlistUrl := "https://www.youtube.com/playlist?list=" + playlistId
 
-	playlistPage, err := downloadPage(playlistUrl)
+	playlistPage, err := downloadPage(ctx, playlistUrl)
 	if err != nil {
-		return
+		return Playlist{}, err
 	}
 
-	// Video Ids
-	regVideos := regexp.MustCompile(`href="\s*/watch\?v=(?P<id>[0-9A-Za-z_-]{11})&amp;[^"]*?index=(?P<index>\d+)(?:[^>]+>(?P<title>[^<]+))?`)
-	matchs := regVideos.FindAllStringSubmatch(playlistPage, -1)
+	playlist := ParsePlaylistPage(playlistPage)
+	playlist.Id = playlistId
+	if len(playlist.Videos) == 0 {
+		return playlist, fmt.Errorf("no videos found in playlist %s", playlistId)
+	}
 
-	// Don't keep duplicates
-	var videoIds []string
-	for _, match := range matchs {
-		found := false
-		for _, videoId := range videoIds {
-			if videoId == match[1] {
-				found = true
+	// Long playlists are listed a page at a time
+	found := map[string]bool{}
+	for _, video := range playlist.Videos {
+		found[video.VideoId] = true
+	}
+	complete := true
+	token := playlistContinuation(playlistPage)
+	apiKey := regInnertubeApiKey.FindStringSubmatch(playlistPage)
+	clientVersion := regInnertubeClientVersion.FindStringSubmatch(playlistPage)
+	for pages := 1; token != "" && apiKey != nil && clientVersion != nil && pages < maxPlaylistPages; pages++ {
+
+		page, err := fetchPlaylistContinuation(ctx, apiKey[1], clientVersion[1], token)
+		if err != nil {
+			if ctx.Err() != nil {
+				return playlist, err
 			}
+			break
+		}
+		added := 0
+		for _, video := range ParsePlaylistPage(page).Videos {
+			if !found[video.VideoId] {
+				found[video.VideoId] = true
+				playlist.Videos = append(playlist.Videos, video)
+				added++
+			}
+		}
+		// A page without new videos wasn't understood, following ones may not be either
+		if added == 0 {
+			complete = false
 		}
+		token = playlistContinuation(page)
+	}
+
+	// Videos may be missing when pages are left or when fewer videos than advertised are listed
+	count := playlistVideoCount(playlistPage)
+	playlist.Complete = complete && token == "" && len(playlist.Videos) >= count
+
+	return playlist, nil
+}
+
+// Pages of a playlist fetched at most, a hundred videos each
+const maxPlaylistPages = 1000
+
+// Match the token of the next page of a playlist
+var regPlaylistContinuation = regexp.MustCompile(`"continuationCommand":\{"token":"([^"]+)"|"nextContinuationData":\{"continuation":"([^"]+)"`)
+
+// Match the api key and client version used by continuation requests
+var regInnertubeApiKey = regexp.MustCompile(`"INNERTUBE_API_KEY":"([^"]+)"`)
+var regInnertubeClientVersion = regexp.MustCompile(`"INNERTUBE_CLIENT_VERSION":"([^"]+)"`)
+
+// Token of the next page of a playlist page or continuation, empty on the last page
+func playlistContinuation(page string) string {
+	match := regPlaylistContinuation.FindStringSubmatch(page)
+	if match == nil {
+		return ""
+	}
+	return match[1] + match[2]
+}
+
+// Fetch the next page of a playlist from the browse api
+// The JSON answer is compacted so continuations are matched like in the initial data of the page
+func fetchPlaylistContinuation(ctx context.Context, apiKey string, clientVersion string, token string) (string, error) {
+
+	body, err := json.Marshal(map[string]interface{}{
+		"context":      map[string]interface{}{"client": map[string]string{"clientName": "WEB", "clientVersion": clientVersion}},
+		"continuation": token,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var page string
+	err = retry(ctx, nil, func() error {
+		req, err := http.NewRequest("POST", "https://www.youtube.com/youtubei/v1/browse?key="+url.QueryEscape(apiKey), bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		addHeaders(req)
+		req.Header.Set("Content-Type", "application/json")
+		page, err = fetchRequest(ctx, req)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, []byte(page)); err != nil {
+		return "", err
+	}
+	return compact.String(), nil
+}
+
+// Match videos of a playlist page and their titles, as links
+var regPlaylistLinks = regexp.MustCompile(`href="\s*/watch\?v=([0-9A-Za-z_-]{11})&amp;[^"]*?index=\d+[^"]*"[^>]*>([^<]*)`)
+
+// Key of the videos of a playlist in the initial data or in continuations
+const playlistVideoRendererKey = `"playlistVideoRenderer":`
+
+// Video of a playlist in the initial data or in continuations
+type playlistVideoRenderer struct {
+	VideoId string `json:"videoId"`
+	Title   struct {
+		SimpleText string `json:"simpleText"`
+		Runs       []struct {
+			Text string `json:"text"`
+		} `json:"runs"`
+	} `json:"title"`
+}
+
+// Match the number of videos advertised by a playlist page
+var regPlaylistVideoCount = regexp.MustCompile(`"numVideosText":\{"runs":\[\{"text":"([\d,.]+)"|"stats":\[\{"runs":\[\{"text":"([\d,.]+)"\},\{"text":" videos?"`)
+
+// Number of videos of a playlist advertised by its page, zero when unknown
+func playlistVideoCount(page string) int {
+	match := regPlaylistVideoCount.FindStringSubmatch(page)
+	if match == nil {
+		return 0
+	}
+	count, _ := strconv.Atoi(strings.NewReplacer(",", "", ".", "").Replace(match[1] + match[2]))
+	return count
+}
+
+// Match the title of a playlist page
+var regPlaylistTitle = regexp.MustCompile(`<meta property="og:title" content="([^"]*)"|<title>([^<]*?)(?: - YouTube)?</title>`)
+
+// Extract title and videos of a playlist page, in playlist order
+func ParsePlaylistPage(page string) Playlist {
+
+	var playlist Playlist
+	if match := regPlaylistTitle.FindStringSubmatch(page); match != nil {
+		playlist.Title = html.UnescapeString(strings.TrimSpace(match[1] + match[2]))
+	}
+
+	// Videos found as links and in the data, kept in page order
+	type pageVideo struct {
+		offset  int
+		videoId string
+		title   string
+	}
+	var videos []pageVideo
+	for _, match := range regPlaylistLinks.FindAllStringSubmatchIndex(page, -1) {
+		title := html.UnescapeString(strings.TrimSpace(page[match[4]:match[5]]))
+		videos = append(videos, pageVideo{match[0], page[match[2]:match[3]], title})
+	}
+	for offset := 0; ; {
+		i := strings.Index(page[offset:], playlistVideoRendererKey)
+		if i < 0 {
+			break
+		}
+		offset += i + len(playlistVideoRendererKey)
+
+		// Renderers are decoded whole, titles come after nested objects
+		var renderer playlistVideoRenderer
+		if err := json.NewDecoder(strings.NewReader(page[offset:])).Decode(&renderer); err != nil || renderer.VideoId == "" {
+			continue
+		}
+		title := renderer.Title.SimpleText
+		for _, run := range renderer.Title.Runs {
+			title += run.Text
+		}
+		videos = append(videos, pageVideo{offset, renderer.VideoId, title})
+	}
+	sort.SliceStable(videos, func(i, j int) bool { return videos[i].offset < videos[j].offset })
+
+	// Don't keep duplicates, links are repeated with and without title
+	found := map[string]int{}
+	for _, video := range videos {
+		if i, ok := found[video.videoId]; ok {
+			if playlist.Videos[i].Title == "" {
+				playlist.Videos[i].Title = video.title
+			}
+			continue
+		}
+		found[video.videoId] = len(playlist.Videos)
+		playlist.Videos = append(playlist.Videos, Video{VideoId: video.videoId, Title: video.title})
+	}
+
+	return playlist
+}
+
+// Fill video details only available in player_response
+func ParsePlayerResponse(videoInfo url.Values, video *Video) error {
+
+	rawResponse := videoInfo.Get("player_response")
+	if rawResponse == "" {
+		return nil
+	}
+
+	var playerResponse PlayerResponse
+	if err := json.Unmarshal([]byte(rawResponse), &playerResponse); err != nil {
+		return err
+	}
+
+	if video.Description == "" {
+		video.Description = playerResponse.VideoDetails.ShortDescription
+	}
+	if views, err := strconv.Atoi(playerResponse.VideoDetails.ViewCount); err == nil {
+		video.ViewCount = views
+	}
+
+	// Dates are YYYY-MM-DD
+	microformat := playerResponse.Microformat.PlayerMicroformatRenderer
+	date := microformat.UploadDate
+	if date == "" {
+		date = microformat.PublishDate
+	}
+	if len(date) >= 10 {
+		video.UploadDate = strings.Replace(date[:10], "-", "", -1)
+	}
 
-		if !found {
-			videoIds = append(videoIds, match[1])
+	// Caption tracks, "asr" ones are automatic captions
+	for _, track := range playerResponse.Captions.PlayerCaptionsTracklistRenderer.CaptionTracks {
+		name := track.Name.SimpleText
+		if name == "" && len(track.Name.Runs) > 0 {
+			name = track.Name.Runs[0].Text
 		}
+		video.Subtitles = append(video.Subtitles, Subtitle{
+			Lang: track.LanguageCode,
+			Name: name,
+			Url:  track.BaseUrl,
+			Auto: track.Kind == "asr",
+		})
 	}
 
-	return
+	return nil
 }
 
 // Get video info from youtube
-func GetVideoInfo(videoId string) (url.Values, error) {
+func GetVideoInfo(ctx context.Context, videoId string) (url.Values, error) {
 
 	// QueryString
 	query := url.Values{
@@ -74,7 +352,7 @@ func GetVideoInfo(videoId string) (url.Values, error) {
 	infoVideoUrl := "http://www.youtube.com/get_video_info?" + query.Encode()
 
 	// Download page
-	rawContent, err := downloadPage(infoVideoUrl)
+	rawContent, err := downloadPage(ctx, infoVideoUrl)
 	if err != nil {
 		return nil, err
 	}